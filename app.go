@@ -2,9 +2,15 @@ package main
 
 import (
 	"artifacts/src"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
@@ -21,13 +27,96 @@ func main() {
 		log.Fatal().Msgf("Failed to connect to db %v\n", err)
 	}
 
-	aux := artifacts.NewCodeArtifactAux(s)
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImport(s, session, os.Args[2:])
+		return
+	}
+
+	importers, err := artifacts.NewImporters(s)
+	if err != nil {
+		log.Fatal().Msgf("Failed to configure importers %v\n", err)
+	}
 
-	if s.Load {
-		log.Info().Fields(s).Msg("Importing artifact lists from AWS codeartifact")
-		go artifacts.LoadArtifacts(err, aux, s, session)
+	if s.Load && len(importers) == 0 {
+		log.Warn().Msg("Nothing to import, set ARTIFACTS_DOMAIN, ARTIFACTS_TARGETS or ARTIFACTS_IMPORTERS")
+	} else if s.Load {
+		log.Info().Stringer("config", s).Msg("Importing artifact lists")
+		go func() {
+			report := artifacts.LoadArtifacts(context.Background(), importers, s, session)
+			log.Info().Interface("report", report).Msg("Finished importing artifact lists")
+		}()
 	}
 	artifacts.LoadTemplates(s)
-	server := artifacts.NewServer(s.Listen, session)
+	events, err := artifacts.EventRoutes(s, session)
+	if err != nil {
+		log.Fatal().Msgf("Failed to configure events %v\n", err)
+	}
+	domains := artifacts.CodeArtifactDomains(importers)
+	server := artifacts.NewServer(s.Listen, session,
+		artifacts.DryRunRoutes(importers, s, session),
+		events,
+		artifacts.StatusRoutes(s, domains, session),
+		artifacts.CleanupRoutes(s, domains, session),
+		artifacts.PromotionRoutes(s, domains, session),
+		artifacts.AssetRoutes(domains, session, s.AssetCache),
+		artifacts.PackageRoutes(domains, session),
+		artifacts.ConfigRoutes(s, domains),
+		artifacts.TopologyRoutes(session),
+		artifacts.OriginRoutes(s, domains, session),
+	)
 	artifacts.StartServer(server)
 }
+
+// runImport imports once and prints the report instead of serving. With --from-dir it reads a local Maven or
+// npm directory rather than the configured importers, and with --dry-run it only prints what would change. An
+// interrupt, or --timeout running out, stops the import where it is, leaving a checkpoint to resume from.
+func runImport(s artifacts.Specification, session *artifacts.BoltStorage, args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	fromDir := flags.String("from-dir", "", "catalogue a Maven or npm directory instead of the configured importers")
+	format := flags.String("format", "", "only read the maven or npm layout from --from-dir")
+	repository := flags.String("repository", "", "repository to file --from-dir artifacts under, defaulting to the directory name")
+	dryRun := flags.Bool("dry-run", false, "print what the import would change instead of changing it")
+	fresh := flags.Bool("fresh", false, "start from the beginning instead of resuming an interrupted import")
+	timeout := flags.Duration("timeout", 0, "stop the import after this long, e.g. 30m")
+	_ = flags.Parse(args)
+	if *fresh {
+		s.Fresh = true
+	}
+
+	if *fromDir != "" {
+		s.Importers = artifacts.ImporterConfigs{{Type: "dir", Path: *fromDir, Format: *format, Repository: *repository}}
+	}
+	importers, err := artifacts.NewImporters(s)
+	if err != nil {
+		log.Fatal().Msgf("Failed to configure importers %v\n", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	if *dryRun {
+		// the summary is for whoever is watching, the JSON for whatever it's piped to
+		diff := artifacts.DryRun(ctx, importers, s, session)
+		fmt.Fprint(os.Stderr, diff.Summary())
+		if err := encoder.Encode(diff); err != nil {
+			log.Fatal().Msgf("Failed to write diff %v\n", err)
+		}
+		return
+	}
+
+	report := artifacts.LoadArtifacts(ctx, importers, s, session)
+	if err := encoder.Encode(report); err != nil {
+		log.Fatal().Msgf("Failed to write report %v\n", err)
+	}
+	if report.Aborted || len(report.Failures) > 0 {
+		os.Exit(1)
+	}
+}
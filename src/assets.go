@@ -0,0 +1,338 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact/types"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+	"hash"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var assetsBucket = []byte("assets")
+
+var (
+	ErrAssetNotFound    = errors.New("no such asset")
+	errChecksumMismatch = errors.New("asset doesn't match the checksums CodeArtifact lists for it")
+)
+
+// AssetHashes are the hashes CodeArtifact lists for the files of a version, by file name and then by algorithm,
+// e.g. "SHA-256".
+type AssetHashes map[string]map[string]string
+
+// AssetHashes returns the stored hashes of the artifact's files, or nil if none are stored.
+func (rs *BoltStorage) AssetHashes(id ArtifactId) (AssetHashes, error) {
+	key, err := id.Key()
+	if err != nil {
+		return nil, err
+	}
+	var hashes AssetHashes
+	err = rs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(assetsBucket)
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get(key)
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &hashes)
+	})
+	return hashes, err
+}
+
+// SaveAssetHashes replaces the stored hashes of the artifact's files.
+func (rs *BoltStorage) SaveAssetHashes(id ArtifactId, hashes AssetHashes) error {
+	key, err := id.Key()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(hashes)
+	if err != nil {
+		return err
+	}
+	return rs.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(assetsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, value)
+	})
+}
+
+var hashAlgorithms = map[string]func() hash.Hash{
+	string(types.HashAlgorithmMd5):    md5.New,
+	string(types.HashAlgorithmSha1):   sha1.New,
+	string(types.HashAlgorithmSha256): sha256.New,
+	string(types.HashAlgorithmSha512): sha512.New,
+}
+
+// digester hashes what is written to it with every algorithm CodeArtifact lists hashes in.
+type digester map[string]hash.Hash
+
+func newDigester() digester {
+	d := make(digester, len(hashAlgorithms))
+	for algorithm, h := range hashAlgorithms {
+		d[algorithm] = h()
+	}
+	return d
+}
+
+func (d digester) Write(p []byte) (int, error) {
+	for _, h := range d {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// matches checks every expected hash in an algorithm the digester knows, and fails when there are none.
+func (d digester) matches(expected map[string]string) error {
+	checked := 0
+	for algorithm, want := range expected {
+		h, ok := d[algorithm]
+		if !ok {
+			continue
+		}
+		if !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), want) {
+			return fmt.Errorf("%w: %s differs", errChecksumMismatch, algorithm)
+		}
+		checked++
+	}
+	if checked == 0 {
+		return fmt.Errorf("%w: no known hashes", errChecksumMismatch)
+	}
+	return nil
+}
+
+// assetTypes covers package files mime doesn't know. Anything else is typed by extension, or sniffed.
+var assetTypes = map[string]string{
+	".jar":    "application/java-archive",
+	".war":    "application/java-archive",
+	".pom":    "application/xml",
+	".module": "application/json",
+	".tgz":    "application/gzip",
+	".whl":    "application/zip",
+	".nupkg":  "application/zip",
+	".snupkg": "application/zip",
+}
+
+func assetContentType(name string) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	if t, ok := assetTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// assetProxy downloads assets from CodeArtifact with the server's credentials, so people without their own can
+// fetch them. Downloads are checked against the hashes CodeArtifact lists before anything is served, and kept
+// under cache when it is set.
+type assetProxy struct {
+	domains Domains
+	session *BoltStorage
+	cache   string
+}
+
+// hashes returns the hashes of the artifact's file called name, listing them from CodeArtifact when they aren't
+// stored or refresh is set.
+func (p *assetProxy) hashes(ctx context.Context, artifact *Artifact, wrapper *CodeArtifactWrapper, name string, refresh bool) (map[string]string, error) {
+	if !refresh {
+		stored, err := p.session.AssetHashes(artifact.ArtifactId)
+		if err != nil {
+			return nil, err
+		}
+		if h, ok := stored[name]; ok {
+			return h, nil
+		}
+	}
+
+	assets, err := wrapper.VersionAssets(ctx, artifact.Repository, artifact.Format, artifact.Namespace, artifact.Package, artifact.Version)
+	if err != nil {
+		return nil, err
+	}
+	listed := make(AssetHashes, len(assets))
+	for _, asset := range assets {
+		listed[aws.ToString(asset.Name)] = asset.Hashes
+	}
+	if err := p.session.SaveAssetHashes(artifact.ArtifactId, listed); err != nil {
+		return nil, err
+	}
+	h, ok := listed[name]
+	if !ok {
+		return nil, ErrAssetNotFound
+	}
+	return h, nil
+}
+
+func (p *assetProxy) path(artifact *Artifact, name string) string {
+	namespace := artifact.Namespace
+	if namespace == "" {
+		namespace = "-"
+	}
+	return filepath.Join(p.cache, artifact.DomainName, artifact.Repository, namespace, artifact.Package, artifact.Version, name)
+}
+
+// open returns the verified asset called name of the artifact with id, and whether it is kept in the cache.
+// Assets that aren't are in a temporary file the caller removes once done. Copies of a version hold the same
+// files, so any stored copy at the location will do.
+func (p *assetProxy) open(ctx context.Context, id ArtifactId, at Location, name string) (*os.File, bool, error) {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, false, ErrAssetNotFound
+	}
+	copies, err := p.session.Copies(id, at)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(copies) == 0 {
+		return nil, false, ErrAssetNotFound
+	}
+	artifact := &copies[0]
+	wrapper, err := p.domains.For(*artifact)
+	if err != nil {
+		return nil, false, err
+	}
+	expected, err := p.hashes(ctx, artifact, wrapper, name, false)
+	if err != nil {
+		return nil, false, err
+	}
+
+	dir := os.TempDir()
+	if p.cache != "" {
+		cached, err := os.Open(p.path(artifact, name))
+		if err == nil {
+			d := newDigester()
+			_, err = io.Copy(d, cached)
+			if err == nil {
+				err = d.matches(expected)
+			}
+			if err == nil {
+				_, err = cached.Seek(0, io.SeekStart)
+			}
+			if err == nil {
+				return cached, true, nil
+			}
+			log.Warn().Err(err).Str("asset", cached.Name()).Msg("Discarding cached asset")
+			_ = cached.Close()
+		}
+		dir = filepath.Dir(p.path(artifact, name))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, false, err
+		}
+	}
+
+	download, err := p.download(ctx, artifact, wrapper, name, dir, expected)
+	if err != nil {
+		return nil, false, err
+	}
+	if p.cache == "" {
+		return download, false, nil
+	}
+	if err := os.Rename(download.Name(), p.path(artifact, name)); err != nil {
+		_ = download.Close()
+		_ = os.Remove(download.Name())
+		return nil, false, err
+	}
+	return download, true, nil
+}
+
+// download fetches the asset into a temporary file in dir, checking it against expected, or against freshly
+// listed hashes in case the version was republished since they were stored.
+func (p *assetProxy) download(ctx context.Context, artifact *Artifact, wrapper *CodeArtifactWrapper, name, dir string, expected map[string]string) (*os.File, error) {
+	response, err := wrapper.Asset(ctx, artifact.Repository, artifact.Format, artifact.Namespace, artifact.Package, artifact.Version, name)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Asset.Close()
+
+	file, err := os.CreateTemp(dir, ".download-*")
+	if err != nil {
+		return nil, err
+	}
+	d := newDigester()
+	_, err = io.Copy(io.MultiWriter(file, d), response.Asset)
+	if err == nil {
+		if err = d.matches(expected); err != nil {
+			var refreshed map[string]string
+			if refreshed, err = p.hashes(ctx, artifact, wrapper, name, true); err == nil {
+				err = d.matches(refreshed)
+			}
+		}
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, err
+	}
+	return file, nil
+}
+
+func assetErrorStatus(err error) int {
+	var notFound *types.ResourceNotFoundException
+	switch {
+	case errors.Is(err, ErrAssetNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// AssetRoutes serves GET /artifacts/{namespace}/{package}/{version}/assets/{name}, which downloads a file of a
+// version from CodeArtifact with the server's credentials. Packages without a namespace are asked for with "-".
+// The optional domain and repository query parameters pick the copy to download from. Files are kept under cache
+// when it is set.
+func AssetRoutes(domains Domains, session *BoltStorage, cache string) Routes {
+	proxy := &assetProxy{domains: domains, session: session, cache: cache}
+	return func(r *mux.Router) {
+		r.Methods("GET").Path("/artifacts/{namespace}/{package}/{version}/assets/{name}").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			vars := mux.Vars(request)
+			id := ArtifactId{Namespace: vars["namespace"], Package: vars["package"], Version: vars["version"]}
+			if id.Namespace == "-" {
+				id.Namespace = ""
+			}
+			at := Location{DomainName: request.URL.Query().Get("domain"), Repository: request.URL.Query().Get("repository")}
+			file, cached, err := proxy.open(request.Context(), id, at, vars["name"])
+			if err != nil {
+				log.Error().Err(err).Str("artifact", id.Ref()).Str("asset", vars["name"]).Msg("Failed to fetch asset")
+				http.Error(writer, err.Error(), assetErrorStatus(err))
+				return
+			}
+			defer func() {
+				_ = file.Close()
+				if !cached {
+					_ = os.Remove(file.Name())
+				}
+			}()
+			info, err := file.Stat()
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if t := assetContentType(vars["name"]); t != "" {
+				writer.Header().Set("Content-Type", t)
+			}
+			writer.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": vars["name"]}))
+			http.ServeContent(writer, request, vars["name"], info.ModTime(), file)
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package artifacts
+
+import (
+	"artifacts/src/codeartifacttest"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAssetRoutes(t *testing.T) {
+	server, s := fakeCodeArtifact(t)
+	server.AddPackage("internal", codeartifacttest.Package{Format: "maven", Namespace: "com.acme", Name: "gizmo", Versions: []codeartifacttest.Version{
+		{Version: "1.0.0", Status: "Published", Assets: []codeartifacttest.Asset{
+			{Name: "gizmo-1.0.0.jar", Content: []byte("tampered"), Hashes: map[string]string{"SHA-256": "00"}},
+		}},
+	}})
+	storage := newTestStorage(t)
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadArtifacts(context.Background(), importers, s, storage)
+	cache := t.TempDir()
+	router := initRouting(storage, AssetRoutes(CodeArtifactDomains(importers), storage, cache))
+	get := func(path string) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest("GET", path, nil))
+		return response
+	}
+
+	response := get("/artifacts/com.acme/widget/1.0.0/assets/widget-1.0.0.jar")
+	if response.Code != 200 || response.Body.String() != "PK widget classes" {
+		t.Fatalf("Expected the jar, got %d %s", response.Code, response.Body)
+	}
+	if response.Header().Get("Content-Type") != "application/java-archive" {
+		t.Errorf("Expected the jar's content type, got %s", response.Header().Get("Content-Type"))
+	}
+	if response.Header().Get("Content-Disposition") != "attachment; filename=widget-1.0.0.jar" {
+		t.Errorf("Expected the jar as an attachment, got %s", response.Header().Get("Content-Disposition"))
+	}
+	if hashes, _ := storage.AssetHashes(ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.0.0"}); len(hashes) != 2 || hashes["widget-1.0.0.pom"]["SHA-256"] == "" {
+		t.Errorf("Expected the hashes of both files to be stored, got %v", hashes)
+	}
+
+	cached := filepath.Join(cache, "acme", "internal", "com.acme", "widget", "1.0.0", "widget-1.0.0.jar")
+	if err := os.WriteFile(cached, []byte("corrupt"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if response := get("/artifacts/com.acme/widget/1.0.0/assets/widget-1.0.0.jar"); response.Body.String() != "PK widget classes" {
+		t.Errorf("Expected a corrupt cached file to be downloaded again, got %s", response.Body)
+	}
+
+	if response := get("/artifacts/com.acme/gizmo/1.0.0/assets/gizmo-1.0.0.jar"); response.Code != 502 {
+		t.Errorf("Expected a file that doesn't match its hashes to be refused, got %d %s", response.Code, response.Body)
+	}
+	for _, path := range []string{
+		"/artifacts/com.acme/widget/1.0.0/assets/widget-1.0.0-sources.jar",
+		"/artifacts/com.acme/widget/9.9.9/assets/widget-9.9.9.jar",
+	} {
+		if response := get(path); response.Code != 404 {
+			t.Errorf("Expected %s not to be found, got %d %s", path, response.Code, response.Body)
+		}
+	}
+
+	server.RemoveRepository("internal")
+	if response := get("/artifacts/com.acme/widget/1.0.0/assets/widget-1.0.0.jar"); response.Code != 200 || response.Body.String() != "PK widget classes" {
+		t.Errorf("Expected the jar from the cache, got %d %s", response.Code, response.Body)
+	}
+	if response := get("/artifacts/com.acme/widget/1.0.0/assets/widget-1.0.0.pom"); response.Code != 404 {
+		t.Errorf("Expected an uncached file of a removed repository not to be found, got %d %s", response.Code, response.Body)
+	}
+}
@@ -1,126 +1,404 @@
 package artifacts
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/codeartifact"
+	"context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"time"
 )
 
+// CodeArtifactAPI is the part of the CodeArtifact client the wrapper uses.
+type CodeArtifactAPI interface {
+	codeartifact.ListPackageVersionsAPIClient
+	codeartifact.ListPackagesAPIClient
+	codeartifact.ListRepositoriesInDomainAPIClient
+	codeartifact.ListPackageVersionAssetsAPIClient
+	DescribePackageVersion(ctx context.Context, params *codeartifact.DescribePackageVersionInput, optFns ...func(*codeartifact.Options)) (*codeartifact.DescribePackageVersionOutput, error)
+	UpdatePackageVersionsStatus(ctx context.Context, params *codeartifact.UpdatePackageVersionsStatusInput, optFns ...func(*codeartifact.Options)) (*codeartifact.UpdatePackageVersionsStatusOutput, error)
+	DisposePackageVersions(ctx context.Context, params *codeartifact.DisposePackageVersionsInput, optFns ...func(*codeartifact.Options)) (*codeartifact.DisposePackageVersionsOutput, error)
+	DeletePackageVersions(ctx context.Context, params *codeartifact.DeletePackageVersionsInput, optFns ...func(*codeartifact.Options)) (*codeartifact.DeletePackageVersionsOutput, error)
+	CopyPackageVersions(ctx context.Context, params *codeartifact.CopyPackageVersionsInput, optFns ...func(*codeartifact.Options)) (*codeartifact.CopyPackageVersionsOutput, error)
+	GetPackageVersionAsset(ctx context.Context, params *codeartifact.GetPackageVersionAssetInput, optFns ...func(*codeartifact.Options)) (*codeartifact.GetPackageVersionAssetOutput, error)
+	GetPackageVersionReadme(ctx context.Context, params *codeartifact.GetPackageVersionReadmeInput, optFns ...func(*codeartifact.Options)) (*codeartifact.GetPackageVersionReadmeOutput, error)
+	GetRepositoryEndpoint(ctx context.Context, params *codeartifact.GetRepositoryEndpointInput, optFns ...func(*codeartifact.Options)) (*codeartifact.GetRepositoryEndpointOutput, error)
+	GetAuthorizationToken(ctx context.Context, params *codeartifact.GetAuthorizationTokenInput, optFns ...func(*codeartifact.Options)) (*codeartifact.GetAuthorizationTokenOutput, error)
+	DescribeRepository(ctx context.Context, params *codeartifact.DescribeRepositoryInput, optFns ...func(*codeartifact.Options)) (*codeartifact.DescribeRepositoryOutput, error)
+	PutPackageOriginConfiguration(ctx context.Context, params *codeartifact.PutPackageOriginConfigurationInput, optFns ...func(*codeartifact.Options)) (*codeartifact.PutPackageOriginConfigurationOutput, error)
+}
+
+// CodeArtifactWrapper talks to a single CodeArtifact domain, described by Target. Client is an interface so tests
+// can substitute their own. TokenClient, when set, requests the tokens handed to users instead of Client.
 type CodeArtifactWrapper struct {
 	Specification
-	Client *codeartifact.CodeArtifact
+	Target      ImportTarget
+	Client      CodeArtifactAPI
+	TokenClient CodeArtifactAPI
 }
 
-func NewCodeArtifactAux(s Specification) CodeArtifactWrapper {
-	whatIsASessionLol := session.Must(session.NewSession())
-	client := codeartifact.New(whatIsASessionLol, aws.NewConfig().WithRegion(s.Region).WithCredentialsChainVerboseErrors(true))
-
-	return CodeArtifactWrapper{
+// NewCodeArtifactAux builds a client for target from the default credential chain, assuming target.RoleArn when
+// it is set, and sending requests to target.Endpoint instead of the regional endpoint when that is. Tokens are
+// requested by a second client assuming target.TokenRoleArn with the default credentials, when that is set.
+func NewCodeArtifactAux(s Specification, target ImportTarget) (CodeArtifactWrapper, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(target.Region))
+	if err != nil {
+		return CodeArtifactWrapper{}, err
+	}
+	endpoint := func(o *codeartifact.Options) {
+		if target.Endpoint != "" {
+			o.EndpointResolver = codeartifact.EndpointResolverFromURL(target.Endpoint)
+		}
+	}
+	wrapper := CodeArtifactWrapper{
 		Specification: s,
-		Client:        client,
+		Target:        target,
+	}
+	if target.TokenRoleArn != "" {
+		tokens := cfg.Copy()
+		tokens.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), target.TokenRoleArn))
+		wrapper.TokenClient = codeartifact.NewFromConfig(tokens, endpoint)
+	}
+	if target.RoleArn != "" {
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), target.RoleArn))
 	}
+	wrapper.Client = codeartifact.NewFromConfig(cfg, endpoint)
+	return wrapper, nil
 }
 
-func (s *CodeArtifactWrapper) AllPackageVersions(pack *codeartifact.PackageSummary, repository *codeartifact.RepositorySummary) (codeartifact.ListPackageVersionsOutput, error) {
-	var output = codeartifact.ListPackageVersionsOutput{
-		DefaultDisplayVersion: nil,
-		Format:                nil,
-		Namespace:             nil,
-		NextToken:             nil,
-		Package:               nil,
-		Versions:              make([]*codeartifact.PackageVersionSummary, 0),
+// domainOwner is nil when the target doesn't name an owner, so CodeArtifact assumes the caller's account.
+func (s *CodeArtifactWrapper) domainOwner() *string {
+	if s.Target.DomainOwner == "" {
+		return nil
 	}
+	return &s.Target.DomainOwner
+}
 
-	for {
+func (s *CodeArtifactWrapper) AllPackageVersions(ctx context.Context, pack *types.PackageSummary, repository *types.RepositorySummary) (codeartifact.ListPackageVersionsOutput, error) {
+	output := codeartifact.ListPackageVersionsOutput{
+		Versions: make([]types.PackageVersionSummary, 0),
+	}
 
-		input := codeartifact.ListPackageVersionsInput{
-			Domain:     &s.Domain,
-			MaxResults: s.AwsPageSize(),
-			Namespace:  pack.Namespace,
-			NextToken:  output.NextToken,
-			Package:    pack.Package,
-			Repository: repository.Name,
-			Format:     pack.Format,
+	pages := codeartifact.NewListPackageVersionsPaginator(s.Client, &codeartifact.ListPackageVersionsInput{
+		Domain:      &s.Target.Domain,
+		DomainOwner: s.domainOwner(),
+		MaxResults:  s.AwsPageSize(),
+		Namespace:   pack.Namespace,
+		Package:     pack.Package,
+		Repository:  repository.Name,
+		Format:      pack.Format,
+	})
+	for pages.HasMorePages() {
+		response, err := pages.NextPage(ctx)
+		if err != nil {
+			return output, err
 		}
+		output.DefaultDisplayVersion = response.DefaultDisplayVersion
+		output.Format = response.Format
+		output.Namespace = response.Namespace
+		output.Package = response.Package
+		output.Versions = append(output.Versions, response.Versions...)
+	}
+	return output, nil
+}
 
-		var response, err = s.Client.ListPackageVersions(&input)
+// AllPackagesInRepo lists the packages in repository. A non nil filter narrows the listing server side where
+// CodeArtifact supports it; callers still need to check the namespace prefix.
+func (s *CodeArtifactWrapper) AllPackagesInRepo(ctx context.Context, repository *types.RepositorySummary, filter *PackageFilter) (codeartifact.ListPackagesOutput, error) {
+	output := codeartifact.ListPackagesOutput{
+		Packages: make([]types.PackageSummary, 0),
+	}
+
+	pages := codeartifact.NewListPackagesPaginator(s.Client, s.packagesInput(repository, filter, ""))
+	for pages.HasMorePages() {
+		response, err := pages.NextPage(ctx)
 		if err != nil {
 			return output, err
 		}
+		output.Packages = append(output.Packages, response.Packages...)
+	}
+	return output, nil
+}
 
-		output = codeartifact.ListPackageVersionsOutput{
-			DefaultDisplayVersion: response.DefaultDisplayVersion,
-			Format:                response.Format,
-			Namespace:             response.Namespace,
-			NextToken:             response.NextToken,
-			Package:               response.Package,
-			Versions:              append(output.Versions, response.Versions...),
+// PackagesPage lists one page of the packages in repository, starting from nextToken, or the first page when it's
+// blank. The filter is applied as in AllPackagesInRepo.
+func (s *CodeArtifactWrapper) PackagesPage(ctx context.Context, repository *types.RepositorySummary, filter *PackageFilter, nextToken string) (codeartifact.ListPackagesOutput, error) {
+	response, err := s.Client.ListPackages(ctx, s.packagesInput(repository, filter, nextToken))
+	if err != nil {
+		return codeartifact.ListPackagesOutput{}, err
+	}
+	return *response, nil
+}
 
+func (s *CodeArtifactWrapper) packagesInput(repository *types.RepositorySummary, filter *PackageFilter, nextToken string) *codeartifact.ListPackagesInput {
+	input := &codeartifact.ListPackagesInput{
+		Domain:      &s.Target.Domain,
+		DomainOwner: s.domainOwner(),
+		MaxResults:  s.AwsPageSize(),
+		Repository:  repository.Name,
+	}
+	if filter != nil {
+		if filter.PackagePrefix != "" {
+			input.PackagePrefix = &filter.PackagePrefix
 		}
-
-		if response.NextToken == nil {
-			return output, nil
+		if len(filter.Formats) == 1 {
+			input.Format = types.PackageFormat(filter.Formats[0])
 		}
 	}
-
+	if nextToken != "" {
+		input.NextToken = &nextToken
+	}
+	return input
 }
 
-func (s *CodeArtifactWrapper) AllPackagesInRepo(repository *codeartifact.RepositorySummary) (codeartifact.ListPackagesOutput, error) {
-	output := codeartifact.ListPackagesOutput{
-		NextToken: nil,
-		Packages:  make([]*codeartifact.PackageSummary, 0),
+// DescribeVersion returns the metadata CodeArtifact holds for one version of a package.
+func (s *CodeArtifactWrapper) DescribeVersion(ctx context.Context, p Package, version string) (*types.PackageVersionDescription, error) {
+	response, err := s.Client.DescribePackageVersion(ctx, &codeartifact.DescribePackageVersionInput{
+		Domain:         &s.Target.Domain,
+		DomainOwner:    s.domainOwner(),
+		Format:         p.Format,
+		Namespace:      p.Namespace,
+		Package:        p.Package,
+		PackageVersion: &version,
+		Repository:     p.Name,
+	})
+	if err != nil {
+		return nil, err
 	}
+	return response.PackageVersion, nil
+}
 
-	for {
-		var response, err = s.Client.ListPackages(&codeartifact.ListPackagesInput{
-			Domain:        &s.Domain,
-			MaxResults:    s.AwsPageSize(),
-			NextToken:     output.NextToken,
-			PackagePrefix: nil,
-			Repository:    repository.Name,
-		})
+// AllRepos Lists the repositories in the target domain: Equivalent to aws codeartifact list-repositories-in-domain.
+func (s *CodeArtifactWrapper) AllRepos(ctx context.Context) (codeartifact.ListRepositoriesInDomainOutput, error) {
+	output := codeartifact.ListRepositoriesInDomainOutput{
+		Repositories: make([]types.RepositorySummary, 0),
+	}
 
+	pages := codeartifact.NewListRepositoriesInDomainPaginator(s.Client, &codeartifact.ListRepositoriesInDomainInput{
+		Domain:      &s.Target.Domain,
+		DomainOwner: s.domainOwner(),
+		MaxResults:  s.AwsPageSize(),
+	})
+	for pages.HasMorePages() {
+		response, err := pages.NextPage(ctx)
 		if err != nil {
 			return output, err
 		}
+		output.Repositories = append(output.Repositories, response.Repositories...)
+	}
+	return output, nil
+}
 
-		output = codeartifact.ListPackagesOutput{
-			NextToken: response.NextToken,
-			Packages:  append(output.Packages, response.Packages...),
-		}
+// UpdateStatus moves versions of a package in repository to status, returning which versions CodeArtifact
+// updated and why the others failed.
+func (s *CodeArtifactWrapper) UpdateStatus(ctx context.Context, repository, format, namespace, pack string, versions []string, status Status) (*codeartifact.UpdatePackageVersionsStatusOutput, error) {
+	var ns *string
+	if namespace != "" {
+		ns = &namespace
+	}
+	return s.Client.UpdatePackageVersionsStatus(ctx, &codeartifact.UpdatePackageVersionsStatusInput{
+		Domain:       &s.Target.Domain,
+		DomainOwner:  s.domainOwner(),
+		Format:       types.PackageFormat(format),
+		Namespace:    ns,
+		Package:      &pack,
+		Repository:   &repository,
+		TargetStatus: types.PackageVersionStatus(status),
+		Versions:     versions,
+	})
+}
 
-		if response.NextToken == nil {
-			return output, nil
-		}
+// DisposeVersions disposes of versions of a package in repository, deleting their assets but keeping a record of
+// them.
+func (s *CodeArtifactWrapper) DisposeVersions(ctx context.Context, repository, format, namespace, pack string, versions []string) (*codeartifact.DisposePackageVersionsOutput, error) {
+	var ns *string
+	if namespace != "" {
+		ns = &namespace
 	}
+	return s.Client.DisposePackageVersions(ctx, &codeartifact.DisposePackageVersionsInput{
+		Domain:      &s.Target.Domain,
+		DomainOwner: s.domainOwner(),
+		Format:      types.PackageFormat(format),
+		Namespace:   ns,
+		Package:     &pack,
+		Repository:  &repository,
+		Versions:    versions,
+	})
+}
 
+// DeleteVersions deletes versions of a package in repository outright.
+func (s *CodeArtifactWrapper) DeleteVersions(ctx context.Context, repository, format, namespace, pack string, versions []string) (*codeartifact.DeletePackageVersionsOutput, error) {
+	var ns *string
+	if namespace != "" {
+		ns = &namespace
+	}
+	return s.Client.DeletePackageVersions(ctx, &codeartifact.DeletePackageVersionsInput{
+		Domain:      &s.Target.Domain,
+		DomainOwner: s.domainOwner(),
+		Format:      types.PackageFormat(format),
+		Namespace:   ns,
+		Package:     &pack,
+		Repository:  &repository,
+		Versions:    versions,
+	})
 }
 
-// AllRepos Lists the repositories available to the creds: Equivalent to aws codeartifact list-repositories.
-func (s *CodeArtifactWrapper) AllRepos() (codeartifact.ListRepositoriesOutput, error) {
-	output := codeartifact.ListRepositoriesOutput{
-		NextToken:    nil,
-		Repositories: make([]*codeartifact.RepositorySummary, 0),
+// CopyVersions copies versions of a package from the source repository to destination.
+func (s *CodeArtifactWrapper) CopyVersions(ctx context.Context, source, destination, format, namespace, pack string, versions []string, allowOverwrite, includeFromUpstream bool) (*codeartifact.CopyPackageVersionsOutput, error) {
+	var ns *string
+	if namespace != "" {
+		ns = &namespace
 	}
+	return s.Client.CopyPackageVersions(ctx, &codeartifact.CopyPackageVersionsInput{
+		AllowOverwrite:        &allowOverwrite,
+		DestinationRepository: &destination,
+		Domain:                &s.Target.Domain,
+		DomainOwner:           s.domainOwner(),
+		Format:                types.PackageFormat(format),
+		IncludeFromUpstream:   &includeFromUpstream,
+		Namespace:             ns,
+		Package:               &pack,
+		SourceRepository:      &source,
+		Versions:              versions,
+	})
+}
 
-	for {
-		input := codeartifact.ListRepositoriesInput{
-			MaxResults:       s.AwsPageSize(),
-			NextToken:        output.NextToken,
-			RepositoryPrefix: nil,
-		}
-		response, err := s.Client.ListRepositories(&input)
+// VersionAssets lists the files that make up a version of a package in repository, with their hashes.
+func (s *CodeArtifactWrapper) VersionAssets(ctx context.Context, repository, format, namespace, pack, version string) ([]types.AssetSummary, error) {
+	var ns *string
+	if namespace != "" {
+		ns = &namespace
+	}
+	assets := make([]types.AssetSummary, 0)
+	pages := codeartifact.NewListPackageVersionAssetsPaginator(s.Client, &codeartifact.ListPackageVersionAssetsInput{
+		Domain:         &s.Target.Domain,
+		DomainOwner:    s.domainOwner(),
+		Format:         types.PackageFormat(format),
+		MaxResults:     s.AwsPageSize(),
+		Namespace:      ns,
+		Package:        &pack,
+		PackageVersion: &version,
+		Repository:     &repository,
+	})
+	for pages.HasMorePages() {
+		response, err := pages.NextPage(ctx)
 		if err != nil {
-			return output, err
+			return assets, err
 		}
+		assets = append(assets, response.Assets...)
+	}
+	return assets, nil
+}
 
-		output := codeartifact.ListRepositoriesOutput{
-			NextToken:    response.NextToken,
-			Repositories: append(output.Repositories, response.Repositories...),
-		}
+// Asset opens one file of a version of a package in repository. Callers must close the output's Asset.
+func (s *CodeArtifactWrapper) Asset(ctx context.Context, repository, format, namespace, pack, version, name string) (*codeartifact.GetPackageVersionAssetOutput, error) {
+	var ns *string
+	if namespace != "" {
+		ns = &namespace
+	}
+	return s.Client.GetPackageVersionAsset(ctx, &codeartifact.GetPackageVersionAssetInput{
+		Asset:          &name,
+		Domain:         &s.Target.Domain,
+		DomainOwner:    s.domainOwner(),
+		Format:         types.PackageFormat(format),
+		Namespace:      ns,
+		Package:        &pack,
+		PackageVersion: &version,
+		Repository:     &repository,
+	})
+}
 
-		if response.NextToken == nil {
-			return output, nil
-		}
+// Readme returns the README CodeArtifact extracted from a version of a package in repository, which is blank for
+// formats it doesn't extract them from.
+func (s *CodeArtifactWrapper) Readme(ctx context.Context, repository, format, namespace, pack, version string) (string, error) {
+	var ns *string
+	if namespace != "" {
+		ns = &namespace
+	}
+	response, err := s.Client.GetPackageVersionReadme(ctx, &codeartifact.GetPackageVersionReadmeInput{
+		Domain:         &s.Target.Domain,
+		DomainOwner:    s.domainOwner(),
+		Format:         types.PackageFormat(format),
+		Namespace:      ns,
+		Package:        &pack,
+		PackageVersion: &version,
+		Repository:     &repository,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(response.Readme), nil
+}
+
+// RepositoryEndpoint returns the URL package managers use to reach repository in format.
+func (s *CodeArtifactWrapper) RepositoryEndpoint(ctx context.Context, repository, format string) (string, error) {
+	response, err := s.Client.GetRepositoryEndpoint(ctx, &codeartifact.GetRepositoryEndpointInput{
+		Domain:      &s.Target.Domain,
+		DomainOwner: s.domainOwner(),
+		Format:      types.PackageFormat(format),
+		Repository:  &repository,
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(response.RepositoryEndpoint), nil
+}
+
+// AuthorizationToken returns a token for the domain that lasts duration, or as long as the caller's session when
+// duration is 0, and when it expires. The token carries TokenClient's permissions when that is set.
+func (s *CodeArtifactWrapper) AuthorizationToken(ctx context.Context, duration time.Duration) (string, time.Time, error) {
+	client := s.Client
+	if s.TokenClient != nil {
+		client = s.TokenClient
+	}
+	response, err := client.GetAuthorizationToken(ctx, &codeartifact.GetAuthorizationTokenInput{
+		Domain:          &s.Target.Domain,
+		DomainOwner:     s.domainOwner(),
+		DurationSeconds: aws.Int64(int64(duration.Seconds())),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return aws.ToString(response.AuthorizationToken), aws.ToTime(response.Expiration), nil
+}
+
+// DescribeRepository returns a repository's description, upstreams and external connections.
+func (s *CodeArtifactWrapper) DescribeRepository(ctx context.Context, repository string) (*types.RepositoryDescription, error) {
+	response, err := s.Client.DescribeRepository(ctx, &codeartifact.DescribeRepositoryInput{
+		Domain:      &s.Target.Domain,
+		DomainOwner: s.domainOwner(),
+		Repository:  &repository,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response.Repository, nil
+}
+
+// PutOrigin sets whether versions of a package in repository may be published directly and fetched from
+// upstreams, returning the restrictions CodeArtifact applied.
+func (s *CodeArtifactWrapper) PutOrigin(ctx context.Context, repository, format, namespace, pack, publish, upstream string) (*types.PackageOriginRestrictions, error) {
+	var ns *string
+	if namespace != "" {
+		ns = &namespace
+	}
+	requested := &types.PackageOriginRestrictions{Publish: types.AllowPublish(publish), Upstream: types.AllowUpstream(upstream)}
+	response, err := s.Client.PutPackageOriginConfiguration(ctx, &codeartifact.PutPackageOriginConfigurationInput{
+		Domain:       &s.Target.Domain,
+		DomainOwner:  s.domainOwner(),
+		Format:       types.PackageFormat(format),
+		Namespace:    ns,
+		Package:      &pack,
+		Repository:   &repository,
+		Restrictions: requested,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.OriginConfiguration == nil || response.OriginConfiguration.Restrictions == nil {
+		return requested, nil
 	}
+	return response.OriginConfiguration.Restrictions, nil
 }
@@ -0,0 +1,174 @@
+package artifacts
+
+import (
+	asn1 "encoding/asn1"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+	"sync"
+)
+
+var checkpointsBucket = []byte("checkpoints")
+
+// Checkpoint is where an interrupted CodeArtifact import resumes: the repository being crawled, which of its
+// package listings (one per package filter), the token of the page of packages being imported, and the packages on
+// that page whose versions were all stored. Repositories are crawled in name order, so a checkpoint for a
+// repository that has since been deleted resumes at the next one.
+type Checkpoint struct {
+	Repository     int
+	RepositoryName string
+	Listing        int
+	NextToken      string
+	Completed      []PackageRef
+}
+
+func (c *Checkpoint) completed(ref PackageRef) bool {
+	for _, done := range c.Completed {
+		if done == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// Checkpoint returns the checkpoint saved under key, or nil if there isn't one.
+func (rs *BoltStorage) Checkpoint(key string) (*Checkpoint, error) {
+	var checkpoint *Checkpoint
+	err := rs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(checkpointsBucket)
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(key))
+		if v == nil {
+			return nil
+		}
+		checkpoint = &Checkpoint{}
+		_, err := asn1.Unmarshal(v, checkpoint)
+		return err
+	})
+	return checkpoint, err
+}
+
+func (rs *BoltStorage) SaveCheckpoint(key string, checkpoint Checkpoint) error {
+	value, err := asn1.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return rs.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(checkpointsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), value)
+	})
+}
+
+func (rs *BoltStorage) DeleteCheckpoint(key string) error {
+	return rs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(checkpointsBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(key))
+	})
+}
+
+// checkpointer saves an import's progress as its packages are stored. Pages of packages are registered in the
+// order they're listed and their artifacts are inserted in the same order, but the checkpoint only moves past a
+// page once every package on it is done, so nothing listed before the checkpoint can have been lost. A package
+// that failed is done too, but isn't recorded as completed; the sync report has its failure, and the next run,
+// which starts from the beginning once this one finishes, imports it again. Without storage, as in a dry run, it
+// does nothing.
+type checkpointer struct {
+	mu      sync.Mutex
+	session *BoltStorage
+	key     string
+	pages   []*checkpointPage
+	// last is the position after the most recently finished page
+	last     *Checkpoint
+	finished bool
+	// failed lists the packages whose versions weren't all stored
+	failed []PackageRef
+}
+
+type checkpointPage struct {
+	at          Checkpoint
+	next        Checkpoint
+	outstanding int
+}
+
+func newCheckpointer(session *BoltStorage, key string) *checkpointer {
+	return &checkpointer{session: session, key: key}
+}
+
+// load returns the checkpoint to resume from, if any. When fresh, any saved checkpoint is discarded instead.
+func (c *checkpointer) load(fresh bool) (*Checkpoint, error) {
+	if c.session == nil {
+		return nil, nil
+	}
+	if fresh {
+		return nil, c.session.DeleteCheckpoint(c.key)
+	}
+	return c.session.Checkpoint(c.key)
+}
+
+// page registers a page of packages listed from at, after which listing continues from next. Each of the page's
+// packages must be marked done with the returned function, saying whether its versions were all stored.
+func (c *checkpointer) page(at, next Checkpoint, packages int) func(ref PackageRef) func(stored bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := &checkpointPage{at: at, next: next, outstanding: packages}
+	c.pages = append(c.pages, p)
+	c.save()
+
+	return func(ref PackageRef) func(stored bool) {
+		return func(stored bool) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if stored {
+				p.at.Completed = append(p.at.Completed, ref)
+			} else {
+				c.failed = append(c.failed, ref)
+			}
+			p.outstanding--
+			c.save()
+		}
+	}
+}
+
+// finish marks the import as done listing, so the checkpoint is dropped once the last page is stored.
+func (c *checkpointer) finish() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.finished = true
+	c.save()
+}
+
+// save persists the earliest unfinished page, or once everything has been listed and stored, deletes the
+// checkpoint. Callers hold mu.
+func (c *checkpointer) save() {
+	for len(c.pages) > 0 && c.pages[0].outstanding <= 0 {
+		c.last = &c.pages[0].next
+		c.pages = c.pages[1:]
+	}
+	if c.session == nil {
+		return
+	}
+
+	var err error
+	switch {
+	case len(c.pages) > 0:
+		err = c.session.SaveCheckpoint(c.key, c.pages[0].at)
+	case c.finished:
+		err = c.session.DeleteCheckpoint(c.key)
+		if len(c.failed) > 0 {
+			log.Warn().Str("importer", c.key).Interface("packages", c.failed).Msg("Import finished without storing every package")
+			c.failed = nil
+		}
+	case c.last != nil:
+		err = c.session.SaveCheckpoint(c.key, *c.last)
+	}
+	if err != nil {
+		log.Warn().Err(err).Str("importer", c.key).Msg("Failed to save import checkpoint")
+	}
+}
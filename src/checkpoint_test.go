@@ -0,0 +1,114 @@
+package artifacts
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointer(t *testing.T) {
+	storage := newTestStorage(t)
+	checkpoints := newCheckpointer(storage, "codeartifact:acme")
+	saved := func() *Checkpoint {
+		c, err := storage.Checkpoint("codeartifact:acme")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	widget, gadget := PackageRef{Namespace: "com.acme", Package: "widget"}, PackageRef{Namespace: "com.acme", Package: "gadget"}
+
+	// stored checkpoints decode with empty rather than nil slices
+	none := []PackageRef{}
+	first := Checkpoint{RepositoryName: "internal", Completed: none}
+	second := Checkpoint{RepositoryName: "internal", NextToken: "page-2", Completed: none}
+	third := Checkpoint{RepositoryName: "internal", Listing: 1, Completed: none}
+	one := checkpoints.page(first, second, 2)
+	two := checkpoints.page(second, third, 1)
+	if c := saved(); !reflect.DeepEqual(*c, first) {
+		t.Errorf("Expected to resume from the first page, got %+v", c)
+	}
+
+	one(widget)(true)
+	if c := saved(); c.NextToken != "" || len(c.Completed) != 1 || c.Completed[0] != widget {
+		t.Errorf("Expected the first page with widget completed, got %+v", c)
+	}
+	one(gadget)(false)
+	if c := saved(); !reflect.DeepEqual(*c, second) {
+		t.Errorf("Expected a failed package not to hold the checkpoint back, got %+v", c)
+	}
+	if len(checkpoints.failed) != 1 || checkpoints.failed[0] != gadget {
+		t.Errorf("Expected the gadget to be recorded as failed, got %+v", checkpoints.failed)
+	}
+	two(widget)(true)
+	if c := saved(); !reflect.DeepEqual(*c, third) {
+		t.Errorf("Expected to resume from the next listing, got %+v", c)
+	}
+
+	checkpoints.finish()
+	if c := saved(); c != nil {
+		t.Errorf("Expected a finished import to drop its checkpoint, got %+v", c)
+	}
+
+	if err := storage.SaveCheckpoint("codeartifact:acme", second); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := newCheckpointer(storage, "codeartifact:acme").load(false); c == nil || c.NextToken != "page-2" {
+		t.Errorf("Expected the saved checkpoint, got %+v", c)
+	}
+	if c, _ := newCheckpointer(storage, "codeartifact:acme").load(true); c != nil || saved() != nil {
+		t.Errorf("Expected a fresh import to discard the checkpoint, got %+v", c)
+	}
+}
+
+func TestInsertBatchMarksDone(t *testing.T) {
+	storage := newTestStorage(t)
+	report := NewSyncReport(0)
+	done, failed := 0, 0
+	finished := func(stored bool) {
+		if stored {
+			done++
+		} else {
+			failed++
+		}
+	}
+	as := make(chan Artifact, 2)
+	as <- Artifact{ArtifactId: ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.0.0"}, Format: "maven", Status: Published}
+	as <- Artifact{ArtifactId: ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.1.0"}, Format: "maven", Status: Published}
+	close(as)
+
+	out := make(chan Artifact, 2)
+	forward(as, out, finished)
+	close(out)
+	batch := make([]Artifact, 0)
+	for a := range out {
+		batch = append(batch, a)
+	}
+	insertBatch(batch, storage, report)
+	if done != 1 || failed != 0 || report.Inserted != 2 {
+		t.Errorf("Expected the package to be done once both versions were inserted, got %d and %+v", done, report)
+	}
+
+	as = make(chan Artifact, 2)
+	as <- Artifact{ArtifactId: ArtifactId{Namespace: "com.acme", Package: "gadget", Version: "1.0.0"}, Error: errors.New("boom")}
+	as <- Artifact{ArtifactId: ArtifactId{Namespace: "com.acme", Package: "gadget", Version: "1.1.0"}, Format: "maven", Status: Published}
+	close(as)
+	out = make(chan Artifact, 2)
+	forward(as, out, finished)
+	close(out)
+	batch = batch[:0]
+	for a := range out {
+		batch = append(batch, a)
+	}
+	insertBatch(batch, storage, report)
+	if done != 1 || failed != 1 || len(report.Failures) != 1 {
+		t.Errorf("Expected a package with a failed version to be done as failed, got %d, %d and %+v", done, failed, report)
+	}
+
+	empty := make(chan Artifact)
+	close(empty)
+	forward(empty, out, finished)
+	if done != 2 {
+		t.Errorf("Expected a package without versions to be done at once")
+	}
+}
@@ -0,0 +1,673 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var cleanupsBucket = []byte("cleanups")
+
+// CleanupAction is what a cleanup does to the versions it selects. Disposing keeps a record of a version in
+// CodeArtifact but deletes its assets; deleting removes it entirely.
+type CleanupAction string
+
+const (
+	Dispose CleanupAction = "Dispose"
+	Delete  CleanupAction = "Delete"
+)
+
+type CleanupState string
+
+const (
+	// Planned cleanups wait for someone other than their planner to approve or reject them
+	Planned  CleanupState = "Planned"
+	Rejected CleanupState = "Rejected"
+	// Executing cleanups were approved but not every version has been cleaned up yet. One whose execution
+	// stopped, because a batch failed or the server went away, can be resumed.
+	Executing CleanupState = "Executing"
+	Executed  CleanupState = "Executed"
+)
+
+// CleanupQuery selects the catalog's artifacts a cleanup applies to. Namespace, Package and Version are
+// substrings and Repository is a glob; when CreatedBefore is set, only artifacts created before it are selected.
+// Statuses defaults to every status a version can be cleaned up from.
+type CleanupQuery struct {
+	Statuses      []Status
+	Namespace     string
+	Package       string
+	Version       string
+	Repository    string
+	CreatedBefore time.Time
+}
+
+// narrows reports whether the query selects less than the whole catalog. A Repository glob of only stars matches
+// every repository, so it doesn't count.
+func (q CleanupQuery) narrows() bool {
+	return q.Namespace != "" || q.Package != "" || q.Version != "" || strings.Trim(q.Repository, "*") != "" ||
+		!q.CreatedBefore.IsZero()
+}
+
+// Cleanup is a bulk dispose or delete. It's planned from a query, approved by a second person, and executed in
+// batches, and is kept afterwards as the audit record of who did what.
+type Cleanup struct {
+	Id         string
+	Action     CleanupAction
+	Query      CleanupQuery
+	Plan       []Artifact
+	State      CleanupState
+	PlannedBy  string
+	PlannedAt  time.Time
+	ReviewedBy string    `json:",omitempty"`
+	ReviewedAt time.Time `json:",omitempty"`
+	ResumedBy  []string  `json:",omitempty"`
+	ExecutedAt time.Time `json:",omitempty"`
+	Batches    []CleanupBatch
+	Results    []CleanupResult
+}
+
+// CleanupBatch is one call to CodeArtifact made executing a cleanup, which succeeded if every version in it did.
+type CleanupBatch struct {
+	DomainName string
+	Repository string
+	Namespace  string `json:",omitempty"`
+	Package    string
+	Versions   []string
+	At         time.Time
+	Succeeded  bool
+}
+
+// CleanupResult is what became of one version in an executed cleanup. Resuming a cleanup replaces the results of
+// the versions that failed.
+type CleanupResult struct {
+	Ref        string
+	DomainName string `json:",omitempty"`
+	Repository string
+	Succeeded  bool
+	Error      string `json:",omitempty"`
+}
+
+// runningCleanups are the cleanups being executed by this server, so a cleanup isn't executed twice at once.
+var runningCleanups = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: make(map[string]bool)}
+
+var (
+	ErrCleanupNotFound = errors.New("no such cleanup")
+	errNotPlanned      = errors.New("only planned cleanups can be reviewed")
+	errSelfApproval    = errors.New("cleanups must be approved by someone other than their planner")
+	errNoUser          = errors.New("changes need an authenticated user")
+	errNotExecuting    = errors.New("only cleanups that didn't finish executing can be resumed")
+	errCleanupRunning  = errors.New("the cleanup is already being executed")
+)
+
+func (rs *BoltStorage) SaveCleanup(c *Cleanup) error {
+	value, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return rs.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(cleanupsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(c.Id), value)
+	})
+}
+
+// Cleanup returns the cleanup with id, or ErrCleanupNotFound.
+func (rs *BoltStorage) Cleanup(id string) (*Cleanup, error) {
+	c := &Cleanup{}
+	err := rs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(cleanupsBucket)
+		if bucket == nil {
+			return ErrCleanupNotFound
+		}
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return ErrCleanupNotFound
+		}
+		return json.Unmarshal(v, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Cleanups returns every cleanup, most recently planned first.
+func (rs *BoltStorage) Cleanups() ([]Cleanup, error) {
+	cleanups := make([]Cleanup, 0)
+	err := rs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(cleanupsBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			c := Cleanup{}
+			if err := json.Unmarshal(v, &c); err != nil {
+				return err
+			}
+			cleanups = append(cleanups, c)
+			return nil
+		})
+	})
+	sort.Slice(cleanups, func(i, j int) bool {
+		return cleanups[i].PlannedAt.After(cleanups[j].PlannedAt)
+	})
+	return cleanups, err
+}
+
+// reviewCleanup applies review to a planned cleanup in one transaction, so a cleanup can't be approved twice.
+func (rs *BoltStorage) reviewCleanup(id string, review func(c *Cleanup) error) (*Cleanup, error) {
+	c := &Cleanup{}
+	err := rs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(cleanupsBucket)
+		if bucket == nil {
+			return ErrCleanupNotFound
+		}
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return ErrCleanupNotFound
+		}
+		if err := json.Unmarshal(v, c); err != nil {
+			return err
+		}
+		if c.State != Planned {
+			return errNotPlanned
+		}
+		if err := review(c); err != nil {
+			return err
+		}
+		value, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// PlanCleanup selects the artifacts query matches that action can apply to and saves the plan for review.
+func PlanCleanup(domains Domains, session *BoltStorage, user string, action CleanupAction, query CleanupQuery) (*Cleanup, error) {
+	if user == "" {
+		return nil, errNoUser
+	}
+	if action != Dispose && action != Delete {
+		return nil, fmt.Errorf("unknown cleanup action %q", action)
+	}
+	if !query.narrows() {
+		return nil, errors.New("a cleanup query must narrow the catalog down")
+	}
+	if err := validateGlobs(query.Repository); err != nil {
+		return nil, err
+	}
+
+	statuses := query.Statuses
+	if len(statuses) == 0 {
+		statuses = AllStatuses
+	}
+	candidates, err := session.List(statuses, query.Namespace, query.Package)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := make([]Artifact, 0)
+	for _, a := range candidates {
+		// deleted versions are gone, and disposed ones can only be deleted
+		if a.Status == Deleted || (a.Status == Disposed && action == Dispose) {
+			continue
+		}
+		if !substringMatch(a.Version, query.Version) {
+			continue
+		}
+		if query.Repository != "" && !globMatch(query.Repository, a.Repository) {
+			continue
+		}
+		if !query.CreatedBefore.IsZero() && !a.CreateTime.Before(query.CreatedBefore) {
+			continue
+		}
+		if _, err := domains.For(a); err != nil {
+			continue
+		}
+		plan = append(plan, a)
+	}
+
+	c := &Cleanup{
+		Id:        uuid.NewString(),
+		Action:    action,
+		Query:     query,
+		Plan:      plan,
+		State:     Planned,
+		PlannedBy: user,
+		PlannedAt: time.Now(),
+		Results:   make([]CleanupResult, 0),
+	}
+	if err := session.SaveCleanup(c); err != nil {
+		return nil, err
+	}
+	log.Info().Str("cleanup", c.Id).Str("action", string(action)).Str("user", user).Int("versions", len(plan)).Msg("Planned cleanup")
+	return c, nil
+}
+
+// RejectCleanup closes a planned cleanup without executing it.
+func RejectCleanup(session *BoltStorage, id, user string) (*Cleanup, error) {
+	if user == "" {
+		return nil, errNoUser
+	}
+	c, err := session.reviewCleanup(id, func(c *Cleanup) error {
+		c.State = Rejected
+		c.ReviewedBy = user
+		c.ReviewedAt = time.Now()
+		return nil
+	})
+	if err == nil {
+		log.Info().Str("cleanup", id).Str("user", user).Msg("Rejected cleanup")
+	}
+	return c, err
+}
+
+// claimCleanup marks the cleanup with id as being executed, reporting false if it already was. The claim is
+// released with releaseCleanup once execution stops.
+func claimCleanup(id string) bool {
+	runningCleanups.Lock()
+	defer runningCleanups.Unlock()
+	if runningCleanups.ids[id] {
+		return false
+	}
+	runningCleanups.ids[id] = true
+	return true
+}
+
+func releaseCleanup(id string) {
+	runningCleanups.Lock()
+	defer runningCleanups.Unlock()
+	delete(runningCleanups.ids, id)
+}
+
+func cleanupRunning(id string) bool {
+	runningCleanups.Lock()
+	defer runningCleanups.Unlock()
+	return runningCleanups.ids[id]
+}
+
+// approveCleanup marks a planned cleanup as approved by user, who mustn't be the one who planned it, and claims
+// it for execution.
+func approveCleanup(session *BoltStorage, id, user string) (*Cleanup, error) {
+	if user == "" {
+		return nil, errNoUser
+	}
+	c, err := session.reviewCleanup(id, func(c *Cleanup) error {
+		if c.PlannedBy == user {
+			return errSelfApproval
+		}
+		c.State = Executing
+		c.ReviewedBy = user
+		c.ReviewedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Info().Str("cleanup", id).Str("user", user).Msg("Approved cleanup")
+	if !claimCleanup(id) {
+		return nil, errCleanupRunning
+	}
+	return c, nil
+}
+
+// ApproveCleanup approves a planned cleanup on behalf of user and executes it.
+func ApproveCleanup(ctx context.Context, domains Domains, session *BoltStorage, id, user string, batchSize int) (*Cleanup, error) {
+	c, err := approveCleanup(session, id, user)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseCleanup(id)
+	return c, executeCleanup(ctx, domains, session, c, batchSize)
+}
+
+// resumeCleanup claims a cleanup whose execution stopped part way, to be picked up on behalf of user.
+func resumeCleanup(session *BoltStorage, id, user string) (*Cleanup, error) {
+	if user == "" {
+		return nil, errNoUser
+	}
+	c, err := session.Cleanup(id)
+	if err != nil {
+		return nil, err
+	}
+	if c.State != Executing {
+		return nil, errNotExecuting
+	}
+	if !claimCleanup(id) {
+		return nil, errCleanupRunning
+	}
+	c.ResumedBy = append(c.ResumedBy, user)
+	if err := session.SaveCleanup(c); err != nil {
+		releaseCleanup(id)
+		return nil, err
+	}
+	log.Info().Str("cleanup", id).Str("user", user).Msg("Resumed cleanup")
+	return c, nil
+}
+
+// ResumeCleanup executes the versions of an approved cleanup that haven't been cleaned up yet, on behalf of user.
+func ResumeCleanup(ctx context.Context, domains Domains, session *BoltStorage, id, user string, batchSize int) (*Cleanup, error) {
+	c, err := resumeCleanup(session, id, user)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseCleanup(id)
+	return c, executeCleanup(ctx, domains, session, c, batchSize)
+}
+
+// executeCleanup sends the versions of a claimed cleanup that haven't succeeded yet to CodeArtifact, batchSize
+// at a time, and updates the catalog for each version CodeArtifact confirmed. Progress is saved after every
+// batch. The cleanup is Executed once every version has succeeded; otherwise it stays Executing, to be resumed.
+func executeCleanup(ctx context.Context, domains Domains, session *BoltStorage, c *Cleanup, batchSize int) error {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	done := make(map[string]bool)
+	results := make([]CleanupResult, 0, len(c.Plan))
+	for _, r := range c.Results {
+		if r.Succeeded {
+			done[r.Ref+"@"+r.DomainName+"/"+r.Repository] = true
+			results = append(results, r)
+		}
+	}
+	c.Results = results
+	remaining := make([]Artifact, 0, len(c.Plan))
+	for _, a := range c.Plan {
+		if !done[a.LocatedRef()] {
+			remaining = append(remaining, a)
+		}
+	}
+
+	failed := false
+	groups, errs := byPackage(domains, remaining)
+	for i, err := range errs {
+		failed = true
+		a := remaining[i]
+		c.Results = append(c.Results, CleanupResult{Ref: a.Ref(), DomainName: a.DomainName, Repository: a.Repository, Error: err.Error()})
+	}
+	for _, group := range groups {
+		for start := 0; start < len(group.versions); start += batchSize {
+			end := start + batchSize
+			if end > len(group.versions) {
+				end = len(group.versions)
+			}
+			batch := executeBatch(ctx, c.Action, group, group.versions[start:end], group.indices[start:end], remaining, session)
+			succeeded := true
+			for _, r := range batch {
+				succeeded = succeeded && r.Succeeded
+			}
+			failed = failed || !succeeded
+			c.Results = append(c.Results, batch...)
+			c.Batches = append(c.Batches, CleanupBatch{
+				DomainName: remaining[group.indices[start]].DomainName,
+				Repository: group.repository,
+				Namespace:  group.namespace,
+				Package:    group.pack,
+				Versions:   group.versions[start:end],
+				At:         time.Now(),
+				Succeeded:  succeeded,
+			})
+			// progress survives a crash part way through
+			if err := session.SaveCleanup(c); err != nil {
+				log.Error().Err(err).Str("cleanup", c.Id).Msg("Failed to save cleanup progress")
+			}
+		}
+	}
+
+	if !failed {
+		c.State = Executed
+		c.ExecutedAt = time.Now()
+	}
+	if err := session.SaveCleanup(c); err != nil {
+		return err
+	}
+	log.Info().Str("cleanup", c.Id).Str("state", string(c.State)).Interface("results", c.Results).Msg("Executed cleanup")
+	return nil
+}
+
+func executeBatch(ctx context.Context, action CleanupAction, group *packageVersions, versions []string, indices []int, plan []Artifact, session *BoltStorage) []CleanupResult {
+	var successful map[string]types.SuccessfulPackageVersionInfo
+	var failed map[string]types.PackageVersionError
+	var err error
+	status := Disposed
+	if action == Dispose {
+		var response *codeartifact.DisposePackageVersionsOutput
+		response, err = group.wrapper.DisposeVersions(ctx, group.repository, group.format, group.namespace, group.pack, versions)
+		if err == nil {
+			successful, failed = response.SuccessfulVersions, response.FailedVersions
+		}
+	} else {
+		status = Deleted
+		var response *codeartifact.DeletePackageVersionsOutput
+		response, err = group.wrapper.DeleteVersions(ctx, group.repository, group.format, group.namespace, group.pack, versions)
+		if err == nil {
+			successful, failed = response.SuccessfulVersions, response.FailedVersions
+		}
+	}
+
+	results := make([]CleanupResult, 0, len(versions))
+	for _, i := range indices {
+		a := plan[i]
+		result := CleanupResult{Ref: a.Ref(), DomainName: a.DomainName, Repository: a.Repository}
+		if err == nil {
+			_, err := versionOutcome(a.Version, successful, failed)
+			if err != nil {
+				result.Error = err.Error()
+			} else if _, err := session.SetStatus(status, a); err != nil {
+				result.Error = fmt.Sprintf("done in CodeArtifact, but not the catalog: %v", err)
+			} else {
+				result.Succeeded = true
+			}
+		} else {
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// CleanupRequest plans a cleanup.
+type CleanupRequest struct {
+	Action CleanupAction
+	Query  CleanupQuery
+}
+
+type CleanupsHtmlContext struct {
+	Cleanups []Cleanup
+	Statuses []Status
+	User     string
+}
+
+type CleanupHtmlContext struct {
+	Cleanup *Cleanup
+	User    string
+}
+
+func cleanupErrorStatus(err error) int {
+	switch err {
+	case ErrCleanupNotFound:
+		return http.StatusNotFound
+	case errNoUser:
+		return http.StatusUnauthorized
+	case errSelfApproval:
+		return http.StatusForbidden
+	case errNotPlanned, errNotExecuting, errCleanupRunning:
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+// cleanupRequest reads a CleanupRequest from JSON or the cleanups page's form.
+func cleanupRequest(request *http.Request) (CleanupRequest, error) {
+	body := CleanupRequest{}
+	if request.Header.Get("Content-Type") == "application/json" {
+		err := json.NewDecoder(request.Body).Decode(&body)
+		return body, err
+	}
+
+	if err := request.ParseForm(); err != nil {
+		return body, err
+	}
+	form := request.PostForm
+	body.Action = CleanupAction(form.Get("action"))
+	body.Query = CleanupQuery{
+		Namespace:  form.Get("namespace"),
+		Package:    form.Get("package"),
+		Version:    form.Get("version"),
+		Repository: form.Get("repository"),
+	}
+	for _, status := range form["status"] {
+		if status != "" {
+			body.Query.Statuses = append(body.Query.Statuses, Status(status))
+		}
+	}
+	if before := form.Get("created_before"); before != "" {
+		t, err := time.Parse("2006-01-02", before)
+		if err != nil {
+			return body, err
+		}
+		body.Query.CreatedBefore = t
+	}
+	return body, nil
+}
+
+// CleanupRoutes serves cleanups. GET /cleanups lists them and POST /cleanups plans one; GET /cleanups/{id} shows a
+// plan or its progress, and POST /cleanups/{id}/approve or /cleanups/{id}/reject reviews it. An approved cleanup
+// is executed in the background, and POST /cleanups/{id}/resume picks up one whose execution stopped. Requests with
+// Content-Type: application/json are answered in JSON, others with the HTML pages, whose forms must be posted from
+// the catalog's own pages. Changes are made on behalf of the user named by the s.UserHeader header.
+func CleanupRoutes(s Specification, domains Domains, session *BoltStorage) Routes {
+	respond := func(writer http.ResponseWriter, request *http.Request, c *Cleanup, err error) {
+		if err != nil {
+			http.Error(writer, err.Error(), cleanupErrorStatus(err))
+			return
+		}
+		if request.Header.Get("Content-Type") == "application/json" {
+			jsonObjects, err := json.Marshal(c)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			_, _ = writer.Write(jsonObjects)
+			return
+		}
+		if request.Method == "POST" {
+			http.Redirect(writer, request, "/cleanups/"+c.Id, http.StatusSeeOther)
+			return
+		}
+		renderTemplate(writer, "cleanup", CleanupHtmlContext{Cleanup: c, User: request.Header.Get(s.UserHeader)})
+	}
+
+	// JSON can't be posted from another site's pages without CORS, which the catalog doesn't allow
+	crossSite := func(writer http.ResponseWriter, request *http.Request) bool {
+		if request.Header.Get("Content-Type") == "application/json" || sameSite(request) {
+			return false
+		}
+		http.Error(writer, "Forms must be posted from the catalog", http.StatusForbidden)
+		return true
+	}
+
+	return func(r *mux.Router) {
+		r.Methods("GET").Path("/cleanups").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			cleanups, err := session.Cleanups()
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if request.Header.Get("Content-Type") == "application/json" {
+				jsonObjects, err := json.Marshal(cleanups)
+				if err != nil {
+					http.Error(writer, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				writer.Header().Set("Content-Type", "application/json")
+				_, _ = writer.Write(jsonObjects)
+				return
+			}
+			renderTemplate(writer, "cleanups", CleanupsHtmlContext{Cleanups: cleanups, Statuses: AllStatuses, User: request.Header.Get(s.UserHeader)})
+		})
+
+		r.Methods("POST").Path("/cleanups").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if crossSite(writer, request) {
+				return
+			}
+			body, err := cleanupRequest(request)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			c, err := PlanCleanup(domains, session, request.Header.Get(s.UserHeader), body.Action, body.Query)
+			respond(writer, request, c, err)
+		})
+
+		r.Methods("GET").Path("/cleanups/{id}").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			c, err := session.Cleanup(mux.Vars(request)["id"])
+			respond(writer, request, c, err)
+		})
+
+		// executions carry on in the background, whatever becomes of the request that started them
+		execute := func(c *Cleanup) {
+			go func() {
+				defer releaseCleanup(c.Id)
+				if err := executeCleanup(context.Background(), domains, session, c, s.CleanupBatchSize); err != nil {
+					log.Error().Err(err).Str("cleanup", c.Id).Msg("Failed to execute cleanup")
+				}
+			}()
+		}
+
+		r.Methods("POST").Path("/cleanups/{id}/approve").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if crossSite(writer, request) {
+				return
+			}
+			c, err := approveCleanup(session, mux.Vars(request)["id"], request.Header.Get(s.UserHeader))
+			if err == nil {
+				execute(c)
+				c, err = session.Cleanup(c.Id)
+			}
+			respond(writer, request, c, err)
+		})
+
+		r.Methods("POST").Path("/cleanups/{id}/resume").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if crossSite(writer, request) {
+				return
+			}
+			c, err := resumeCleanup(session, mux.Vars(request)["id"], request.Header.Get(s.UserHeader))
+			if err == nil {
+				execute(c)
+				c, err = session.Cleanup(c.Id)
+			}
+			respond(writer, request, c, err)
+		})
+
+		r.Methods("POST").Path("/cleanups/{id}/reject").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if crossSite(writer, request) {
+				return
+			}
+			c, err := RejectCleanup(session, mux.Vars(request)["id"], request.Header.Get(s.UserHeader))
+			respond(writer, request, c, err)
+		})
+	}
+}
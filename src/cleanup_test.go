@@ -0,0 +1,211 @@
+package artifacts
+
+import (
+	"artifacts/src/codeartifacttest"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForCleanup returns the cleanup with id once nothing is executing it.
+func waitForCleanup(t *testing.T, storage *BoltStorage, id string) *Cleanup {
+	for deadline := time.Now().Add(5 * time.Second); cleanupRunning(id) && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c, err := storage.Cleanup(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCleanup(t *testing.T) {
+	server, s := fakeCodeArtifact(t)
+	s.Reconcile = false
+	s.UserHeader = "X-Forwarded-User"
+	storage := newTestStorage(t)
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadArtifacts(context.Background(), importers, s, storage)
+	domains := CodeArtifactDomains(importers)
+
+	if _, err := PlanCleanup(domains, storage, "", Dispose, CleanupQuery{Package: "widget"}); err != errNoUser {
+		t.Errorf("Expected an anonymous plan to be refused, got %v", err)
+	}
+	if _, err := PlanCleanup(domains, storage, "alice", Dispose, CleanupQuery{}); err == nil {
+		t.Error("Expected a query selecting everything to be refused")
+	}
+	if _, err := PlanCleanup(domains, storage, "alice", Dispose, CleanupQuery{Repository: "*"}); err == nil {
+		t.Error("Expected a query for every repository to be refused")
+	}
+
+	c, err := PlanCleanup(domains, storage, "alice", Dispose, CleanupQuery{Package: "widget"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.State != Planned || len(c.Plan) != 3 {
+		t.Fatalf("Expected a plan for the 3 widget versions, got %+v", c)
+	}
+	if _, err := ApproveCleanup(context.Background(), domains, storage, c.Id, "alice", 2); err != errSelfApproval {
+		t.Errorf("Expected the planner not to be able to approve, got %v", err)
+	}
+
+	c, err = ApproveCleanup(context.Background(), domains, storage, c.Id, "bob", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.State != Executed || c.ReviewedBy != "bob" || len(c.Results) != 3 {
+		t.Fatalf("Expected bob to have executed the cleanup, got %+v", c)
+	}
+	for _, result := range c.Results {
+		if !result.Succeeded {
+			t.Errorf("Expected %s to be disposed, got %+v", result.Ref, result)
+		}
+	}
+	if v, _ := server.Version("internal", "maven", "com.acme", "widget", "1.1.0"); v.Status != "Disposed" {
+		t.Errorf("Expected CodeArtifact to have disposed of widget 1.1.0, got %+v", v)
+	}
+	if a, _ := onlyCopy(storage, ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.1.0"}); a == nil || a.Status != Disposed {
+		t.Errorf("Expected the catalog to record widget 1.1.0 as disposed, got %+v", a)
+	}
+	if _, err := ApproveCleanup(context.Background(), domains, storage, c.Id, "carol", 2); err != errNotPlanned {
+		t.Errorf("Expected an executed cleanup not to run again, got %v", err)
+	}
+	if stored, _ := storage.Cleanup(c.Id); stored == nil || stored.State != Executed || len(stored.Results) != 3 {
+		t.Errorf("Expected the audit record to be stored, got %+v", stored)
+	}
+
+	LoadTemplates(Specification{Templates: "templates/"})
+	router := initRouting(storage, CleanupRoutes(s, domains, storage))
+	body, _ := json.Marshal(CleanupRequest{Action: Delete, Query: CleanupQuery{Version: "rc1"}})
+	request := httptest.NewRequest("POST", "/cleanups", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Forwarded-User", "alice")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	planned := Cleanup{}
+	if err := json.Unmarshal(response.Body.Bytes(), &planned); err != nil {
+		t.Fatal(err)
+	}
+	if len(planned.Plan) != 1 || planned.Plan[0].Version != "1.2.0-rc1" {
+		t.Fatalf("Expected a plan to delete the release candidate, got %+v", planned)
+	}
+
+	response = httptest.NewRecorder()
+	request = httptest.NewRequest("GET", "/cleanups/"+planned.Id, nil)
+	request.Header.Set("X-Forwarded-User", "bob")
+	router.ServeHTTP(response, request)
+	if !strings.Contains(response.Body.String(), "/cleanups/"+planned.Id+"/approve") {
+		t.Errorf("Expected bob to be offered approval, got %s", response.Body)
+	}
+
+	response = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/cleanups/"+planned.Id+"/approve", nil)
+	request.Header.Set("X-Forwarded-User", "bob")
+	request.Header.Set("Origin", "https://attacker.example")
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected an approval posted from another site to be refused, got %d", response.Code)
+	}
+	if c, _ := storage.Cleanup(planned.Id); c.State != Planned {
+		t.Errorf("Expected the cleanup to still be planned, got %s", c.State)
+	}
+
+	response = httptest.NewRecorder()
+	request = httptest.NewRequest("POST", "/cleanups/"+planned.Id+"/approve", nil)
+	request.Header.Set("X-Forwarded-User", "bob")
+	request.Header.Set("Origin", "http://example.com")
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusSeeOther {
+		t.Errorf("Expected approval to redirect to the results, got %d %s", response.Code, response.Body)
+	}
+	if executed := waitForCleanup(t, storage, planned.Id); executed.State != Executed {
+		t.Errorf("Expected the approved cleanup to be executed in the background, got %+v", executed)
+	}
+	if _, ok := server.Version("internal", "maven", "com.acme", "widget", "1.2.0-rc1"); ok {
+		t.Error("Expected CodeArtifact to have deleted the release candidate")
+	}
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/cleanups", nil))
+	if response.Code != http.StatusOK || strings.Count(response.Body.String(), `href="/cleanups/`) != 2 {
+		t.Errorf("Expected both cleanups to be listed, got %d %s", response.Code, response.Body)
+	}
+
+	rejected, err := PlanCleanup(domains, storage, "alice", Delete, CleanupQuery{Package: "gadget"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RejectCleanup(storage, rejected.Id, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ApproveCleanup(context.Background(), domains, storage, rejected.Id, "bob", 2); err != errNotPlanned {
+		t.Errorf("Expected a rejected cleanup not to run, got %v", err)
+	}
+}
+
+func TestCleanupResumes(t *testing.T) {
+	server, s := fakeCodeArtifact(t)
+	s.Reconcile = false
+	s.UserHeader = "X-Forwarded-User"
+	storage := newTestStorage(t)
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadArtifacts(context.Background(), importers, s, storage)
+	domains := CodeArtifactDomains(importers)
+
+	c, err := PlanCleanup(domains, storage, "alice", Dispose, CleanupQuery{Package: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the batch fails while the repository is gone
+	server.RemoveRepository("release")
+	c, err = ApproveCleanup(context.Background(), domains, storage, c.Id, "bob", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.State != Executing || len(c.Batches) != 1 || c.Batches[0].Succeeded || len(c.Results) != 1 || c.Results[0].Succeeded {
+		t.Fatalf("Expected the failed batch to leave the cleanup executing, got %+v", c)
+	}
+
+	server.Seed(codeartifacttest.Repository{Name: "release", Packages: []codeartifacttest.Package{
+		{Format: "npm", Namespace: "acme", Name: "client", Versions: []codeartifacttest.Version{{Version: "3.0.0", Status: "Published"}}},
+	}})
+	LoadTemplates(Specification{Templates: "templates/"})
+	router := initRouting(storage, CleanupRoutes(s, domains, storage))
+	request := httptest.NewRequest("POST", "/cleanups/"+c.Id+"/resume", nil)
+	request.Header.Set("Origin", "http://example.com")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an anonymous resume to be refused, got %d", response.Code)
+	}
+	request = httptest.NewRequest("POST", "/cleanups/"+c.Id+"/resume", nil)
+	request.Header.Set("X-Forwarded-User", "carol")
+	request.Header.Set("Origin", "http://example.com")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusSeeOther {
+		t.Errorf("Expected resuming to redirect to the progress, got %d %s", response.Code, response.Body)
+	}
+
+	c = waitForCleanup(t, storage, c.Id)
+	if c.State != Executed || len(c.Batches) != 2 || !c.Batches[1].Succeeded || len(c.Results) != 1 || !c.Results[0].Succeeded || len(c.ResumedBy) != 1 || c.ResumedBy[0] != "carol" {
+		t.Errorf("Expected carol's resume to finish the cleanup, got %+v", c)
+	}
+	if v, _ := server.Version("release", "npm", "acme", "client", "3.0.0"); v.Status != "Disposed" {
+		t.Errorf("Expected CodeArtifact to have disposed of client 3.0.0, got %+v", v)
+	}
+	if _, err := ResumeCleanup(context.Background(), domains, storage, c.Id, "carol", 2); err != errNotExecuting {
+		t.Errorf("Expected an executed cleanup not to be resumed, got %v", err)
+	}
+}
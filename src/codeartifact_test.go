@@ -0,0 +1,305 @@
+package artifacts
+
+import (
+	"artifacts/src/codeartifacttest"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact/types"
+	"testing"
+	"time"
+)
+
+var published = time.Date(2021, 11, 1, 9, 0, 0, 0, time.UTC)
+
+// fakeCodeArtifact serves the acme domain with a maven repository, an npm repository that the returned
+// Specification skips, and a release repository. Every listing is paginated a single item at a time.
+func fakeCodeArtifact(t *testing.T) (*codeartifacttest.Server, Specification) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+
+	server := codeartifacttest.NewServer("acme", "111122223333")
+	t.Cleanup(server.Close)
+	server.Seed(
+		codeartifacttest.Repository{Name: "internal", Packages: []codeartifacttest.Package{
+			{Format: "maven", Namespace: "com.acme", Name: "widget", Versions: []codeartifacttest.Version{
+				{Version: "1.0.0", Revision: "r1", Status: "Published", Summary: "Widgets", Licenses: []string{"MIT"}, SourceCodeRepository: "https://git.acme.example/widget", Published: published, Assets: []codeartifacttest.Asset{
+					{Name: "widget-1.0.0.jar", Content: []byte("PK widget classes")},
+					{Name: "widget-1.0.0.pom", Content: []byte("<project/>")},
+				}},
+				{Version: "1.1.0", Revision: "r2", Status: "Published", Published: published},
+				{Version: "1.2.0-rc1", Revision: "r3", Status: "Unlisted", Published: published},
+			}},
+			{Format: "maven", Namespace: "com.acme", Name: "gadget", Versions: []codeartifacttest.Version{
+				{Version: "2.0.0", Revision: "r4", Status: "Published", Published: published},
+			}},
+		}},
+		codeartifacttest.Repository{Name: "npm-store", Packages: []codeartifacttest.Package{
+			{Format: "npm", Name: "left-pad", Versions: []codeartifacttest.Version{{Version: "1.3.0", Status: "Published"}}},
+		}},
+		codeartifacttest.Repository{Name: "release", Packages: []codeartifacttest.Package{
+			{Format: "npm", Namespace: "acme", Name: "client", Versions: []codeartifacttest.Version{{Version: "3.0.0", Status: "Published"}}},
+		}},
+	)
+
+	s := Specification{
+		Domain:              "acme",
+		Region:              "us-east-1",
+		Endpoint:            server.URL,
+		PageSize:            1,
+		SkipRepos:           []string{"*-store"},
+		Retries:             1,
+		FailureThreshold:    20,
+		Reconcile:           true,
+		ReconcileStatus:     Deleted,
+		Describe:            true,
+		DescribeConcurrency: 2,
+	}
+	return server, s
+}
+
+func staleWidget() Artifact {
+	return Artifact{
+		ArtifactId: ArtifactId{Namespace: "com.acme", Package: "widget", Version: "0.9.0"},
+		Repository: "internal",
+		DomainName: "acme",
+		Format:     "maven",
+		Status:     Published,
+		CreateTime: published,
+	}
+}
+
+func TestAllRepos(t *testing.T) {
+	_, s := fakeCodeArtifact(t)
+	aux, err := NewCodeArtifactAux(s, s.ImportTargets()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos, err := aux.AllRepos(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos.Repositories) != 3 {
+		t.Errorf("Expected every page of repositories, got %+v", repos.Repositories)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	_, s := fakeCodeArtifact(t)
+	aux, err := NewCodeArtifactAux(s, s.ImportTargets()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := Package{
+		RepositorySummary: &types.RepositorySummary{Name: aws.String("internal"), DomainName: aws.String("acme")},
+		PackageSummary:    &types.PackageSummary{Format: types.PackageFormatMaven, Namespace: aws.String("com.acme"), Package: aws.String("widget")},
+	}
+	listed := Artifact{ArtifactId: ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.0.0"}, CreateTime: time.Now()}
+
+	described := describe(context.Background(), listed, p, aux)
+	if described.SourceCodeRepository != "https://git.acme.example/widget" || described.Revision != "r1" {
+		t.Errorf("Expected the source repository and revision to be described, got %+v", described)
+	}
+	if !described.CreateTime.Equal(published) || described.Summary != "Widgets" {
+		t.Errorf("Expected the publish time and summary to be described, got %+v", described)
+	}
+}
+
+func TestCodeArtifactImport(t *testing.T) {
+	_, s := fakeCodeArtifact(t)
+	storage := newTestStorage(t)
+	if _, err := storage.Insert(staleWidget()); err != nil {
+		t.Fatal(err)
+	}
+
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := LoadArtifacts(context.Background(), importers, s, storage)
+	if len(report.Failures) > 0 {
+		t.Fatalf("Unexpected failures %+v", report.Failures)
+	}
+	if report.Repositories != 2 || report.Packages != 3 || report.Inserted != 5 {
+		t.Errorf("Expected 5 versions of 3 packages in 2 repositories, got %+v", report)
+	}
+	if len(report.Reconciled) != 1 || len(report.Reconciled[0].Artifacts) != 1 || report.Reconciled[0].Artifacts[0] != staleWidget().ArtifactId {
+		t.Errorf("Expected the stale widget to be reconciled, got %+v", report.Reconciled)
+	}
+
+	widget, err := onlyCopy(storage, ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.0.0"})
+	if err != nil || widget == nil {
+		t.Fatalf("Expected widget 1.0.0 to be stored: %v", err)
+	}
+	if !widget.CreateTime.Equal(published) || widget.Summary != "Widgets" || len(widget.Licenses) != 1 || widget.Revision != "r1" {
+		t.Errorf("Expected the version description to be stored, got %+v", widget)
+	}
+	if rc, _ := onlyCopy(storage, ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.2.0-rc1"}); rc == nil || rc.Status != Unlisted {
+		t.Errorf("Expected the release candidate to be unlisted, got %+v", rc)
+	}
+	if leftPad, _ := onlyCopy(storage, ArtifactId{Package: "left-pad", Version: "1.3.0"}); leftPad != nil {
+		t.Errorf("Expected the skipped repository not to be imported, got %+v", leftPad)
+	}
+	if c, _ := storage.Checkpoint(importers[0].(*CodeArtifactWrapper).checkpointKey()); c != nil {
+		t.Errorf("Expected a finished import to leave no checkpoint, got %+v", c)
+	}
+}
+
+func TestCodeArtifactImportResumes(t *testing.T) {
+	_, s := fakeCodeArtifact(t)
+	storage := newTestStorage(t)
+	if _, err := storage.Insert(staleWidget()); err != nil {
+		t.Fatal(err)
+	}
+	s.Targets = ImportTargets{{Domain: "acme", DomainOwner: "111122223333"}}
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := importers[0].(*CodeArtifactWrapper).checkpointKey()
+	if key != "codeartifact:111122223333:acme" {
+		t.Errorf("Expected the checkpoint to be kept per domain owner, got %s", key)
+	}
+	// as if the import died after storing the widget, the first page of internal
+	if err := storage.SaveCheckpoint(key, Checkpoint{RepositoryName: "internal", NextToken: "offset-1"}); err != nil {
+		t.Fatal(err)
+	}
+	report := LoadArtifacts(context.Background(), importers, s, storage)
+	if len(report.Failures) > 0 {
+		t.Fatalf("Unexpected failures %+v", report.Failures)
+	}
+	if report.Packages != 2 || report.Inserted != 2 {
+		t.Errorf("Expected the gadget and client to be imported, got %+v", report)
+	}
+	if widget, _ := onlyCopy(storage, ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.0.0"}); widget != nil {
+		t.Errorf("Expected the widget to be skipped, got %+v", widget)
+	}
+	if len(report.Reconciled) != 0 {
+		t.Errorf("Expected the resumed repository not to be reconciled, got %+v", report.Reconciled)
+	}
+	if c, _ := storage.Checkpoint(key); c != nil {
+		t.Errorf("Expected a finished import to leave no checkpoint, got %+v", c)
+	}
+
+	s.Fresh = true
+	importers, _ = NewImporters(s)
+	if err := storage.SaveCheckpoint(key, Checkpoint{RepositoryName: "release"}); err != nil {
+		t.Fatal(err)
+	}
+	report = LoadArtifacts(context.Background(), importers, s, storage)
+	if report.Packages != 3 {
+		t.Errorf("Expected a fresh import to start from the beginning, got %+v", report)
+	}
+}
+
+func TestCodeArtifactImportFailedPackageLeavesNoCheckpoint(t *testing.T) {
+	server, s := fakeCodeArtifact(t)
+	// maven packages need a namespace, so this one can't be stored
+	server.AddPackage("internal", codeartifacttest.Package{Format: "maven", Name: "broken", Versions: []codeartifacttest.Version{{Version: "1.0.0", Status: "Published"}}})
+	storage := newTestStorage(t)
+	s.Targets = ImportTargets{{Domain: "acme", DomainOwner: "111122223333"}}
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := importers[0].(*CodeArtifactWrapper).checkpointKey()
+
+	stale := Artifact{ArtifactId: ArtifactId{Namespace: "acme", Package: "client", Version: "2.0.0"}, DomainName: "acme", Account: "111122223333", Region: "us-east-1", Repository: "release", Format: "npm", Status: Published, CreateTime: published}
+	for run := 0; run < 2; run++ {
+		if run == 1 {
+			if _, err := storage.Insert(stale); err != nil {
+				t.Fatal(err)
+			}
+		}
+		report := LoadArtifacts(context.Background(), importers, s, storage)
+		if len(report.Failures) != 1 || report.Failures[0].Package != "broken" {
+			t.Errorf("Expected the broken package to be reported, got %+v", report.Failures)
+		}
+		if report.Repositories != 2 || report.Packages != 4 || report.Inserted != 5 {
+			t.Errorf("Expected run %d to crawl every repository from the start, got %+v", run, report)
+		}
+		if c, _ := storage.Checkpoint(key); c != nil {
+			t.Errorf("Expected a finished import to leave no checkpoint, got %+v", c)
+		}
+		if run == 1 && (len(report.Reconciled) != 1 || report.Reconciled[0].Repository != "release") {
+			t.Errorf("Expected the release repository to be reconciled again, got %+v", report.Reconciled)
+		}
+	}
+}
+
+func TestCodeArtifactImportCancelled(t *testing.T) {
+	_, s := fakeCodeArtifact(t)
+	storage := newTestStorage(t)
+	if _, err := storage.Insert(staleWidget()); err != nil {
+		t.Fatal(err)
+	}
+
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report := LoadArtifacts(ctx, importers, s, storage)
+	if !report.Aborted || report.Inserted != 0 {
+		t.Errorf("Expected a cancelled import to abort without inserting anything, got %+v", report)
+	}
+	if len(report.Reconciled) != 0 {
+		t.Errorf("Expected a cancelled import not to reconcile, got %+v", report.Reconciled)
+	}
+	if stale, _ := onlyCopy(storage, staleWidget().ArtifactId); stale == nil || stale.Status != Published {
+		t.Errorf("Expected the stale widget to be left alone, got %+v", stale)
+	}
+}
+
+func TestCodeArtifactImportSameNamedDomains(t *testing.T) {
+	_, s := fakeCodeArtifact(t)
+	storage := newTestStorage(t)
+	targets := ImportTargets{
+		{Domain: "acme", DomainOwner: "111122223333", Region: "us-east-1"},
+		{Domain: "acme", DomainOwner: "444455556666", Region: "us-east-1"},
+		{Domain: "acme", DomainOwner: "111122223333", Region: "eu-west-1"},
+	}
+	for i := range targets {
+		server := codeartifacttest.NewServer("acme", targets[i].DomainOwner)
+		t.Cleanup(server.Close)
+		server.Seed(codeartifacttest.Repository{Name: "internal", Packages: []codeartifacttest.Package{
+			{Format: "maven", Namespace: "com.acme", Name: "widget", Versions: []codeartifacttest.Version{
+				{Version: "1.0.0", Status: "Published"},
+				{Version: fmt.Sprintf("2.%d.0", i), Status: "Published"},
+			}},
+		}})
+		targets[i].Endpoint = server.URL
+	}
+	s.Targets = targets
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for run := 0; run < 2; run++ {
+		report := LoadArtifacts(context.Background(), importers, s, storage)
+		if len(report.Failures) > 0 {
+			t.Fatalf("Unexpected failures %+v", report.Failures)
+		}
+		if len(report.Reconciled) != 0 {
+			t.Errorf("Expected no domain to reconcile another's versions, got %+v", report.Reconciled)
+		}
+	}
+	if deleted, _ := storage.List([]Status{Deleted}, "", ""); len(deleted) != 0 {
+		t.Errorf("Expected nothing to be deleted, got %+v", deleted)
+	}
+	copies, err := storage.Copies(ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.0.0"}, Location{})
+	if err != nil || len(copies) != 3 {
+		t.Fatalf("Expected a copy of widget 1.0.0 per domain, got %+v %v", copies, err)
+	}
+	for i, target := range targets {
+		if copies[i].Account == "" || copies[i].Region == "" {
+			t.Errorf("Expected the copy to record its account and region, got %+v", copies[i])
+		}
+		at := Location{DomainName: "acme", Account: target.DomainOwner, Region: target.Region, Repository: "internal"}
+		if c, _ := storage.Get(ArtifactId{Namespace: "com.acme", Package: "widget", Version: fmt.Sprintf("2.%d.0", i)}, at); c == nil || c.Status != Published {
+			t.Errorf("Expected widget 2.%d.0 to be stored at %+v, got %+v", i, at, c)
+		}
+	}
+}
@@ -0,0 +1,820 @@
+// Package codeartifacttest runs an in-process fake of the CodeArtifact API, so code that talks to CodeArtifact
+// can be tested offline. The fake is seeded with repositories, packages and versions, and paginates every
+// listing so paging is exercised.
+package codeartifacttest
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is a package version as CodeArtifact describes it.
+type Version struct {
+	Version              string
+	Revision             string
+	Status               string
+	DisplayName          string
+	Summary              string
+	HomePage             string
+	SourceCodeRepository string
+	Licenses             []string
+	Published            time.Time
+	Assets               []Asset
+	// Readme is what GetPackageVersionReadme returns, as CodeArtifact extracts for npm and PyPI packages
+	Readme string
+	// ExternalConnection is the external connection the version was cached from, if any
+	ExternalConnection string
+}
+
+// Asset is a file of a version. Its hashes are computed from Content unless Hashes is set, which lets tests
+// serve files that don't match what CodeArtifact lists.
+type Asset struct {
+	Name    string
+	Content []byte
+	Hashes  map[string]string
+}
+
+func (a Asset) hashes() map[string]string {
+	if a.Hashes != nil {
+		return a.Hashes
+	}
+	hashes := make(map[string]string)
+	for algorithm, h := range map[string]hash.Hash{"MD5": md5.New(), "SHA-1": sha1.New(), "SHA-256": sha256.New(), "SHA-512": sha512.New()} {
+		h.Write(a.Content)
+		hashes[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return hashes
+}
+
+type Package struct {
+	Format    string
+	Namespace string
+	Name      string
+	Versions  []Version
+	// Publish and Upstream are the package's origin controls, ALLOW when blank
+	Publish  string
+	Upstream string
+}
+
+func (p Package) originConfiguration() map[string]interface{} {
+	restrictions := map[string]string{"publish": "ALLOW", "upstream": "ALLOW"}
+	if p.Publish != "" {
+		restrictions["publish"] = p.Publish
+	}
+	if p.Upstream != "" {
+		restrictions["upstream"] = p.Upstream
+	}
+	return map[string]interface{}{"restrictions": restrictions}
+}
+
+type Repository struct {
+	Name        string
+	Description string
+	Packages    []Package
+	// Upstreams are searched in order, and ExternalConnections name the public registries cached from
+	Upstreams           []string
+	ExternalConnections []string
+}
+
+// Server is a fake CodeArtifact domain. Point a client's endpoint at its URL; requests for any other domain are
+// answered with ResourceNotFoundException.
+type Server struct {
+	*httptest.Server
+	Domain string
+	Owner  string
+	// PageSize caps every page the fake returns, whatever the client asks for
+	PageSize int
+
+	mu           sync.Mutex
+	repositories map[string]*Repository
+}
+
+// NewServer starts a fake of the domain owned by the account owner. Close it when done.
+func NewServer(domain, owner string) *Server {
+	s := &Server{
+		Domain:       domain,
+		Owner:        owner,
+		PageSize:     2,
+		repositories: make(map[string]*Repository),
+	}
+
+	mux := http.NewServeMux()
+	s.handle(mux, "POST", "/v1/domain/repositories", s.listRepositories)
+	s.handle(mux, "POST", "/v1/packages", s.listPackages)
+	s.handle(mux, "POST", "/v1/package/versions", s.listPackageVersions)
+	s.handle(mux, "GET", "/v1/package/version", s.describePackageVersion)
+	s.handle(mux, "POST", "/v1/package/versions/update_status", s.updatePackageVersionsStatus)
+	s.handle(mux, "POST", "/v1/package/versions/dispose", s.disposePackageVersions)
+	s.handle(mux, "POST", "/v1/package/versions/delete", s.deletePackageVersions)
+	s.handle(mux, "POST", "/v1/package/versions/copy", s.copyPackageVersions)
+	s.handle(mux, "POST", "/v1/package/version/assets", s.listPackageVersionAssets)
+	s.handle(mux, "GET", "/v1/package/version/asset", s.getPackageVersionAsset)
+	s.handle(mux, "GET", "/v1/package/version/readme", s.getPackageVersionReadme)
+	s.handle(mux, "GET", "/v1/repository/endpoint", s.getRepositoryEndpoint)
+	s.handle(mux, "POST", "/v1/authorization-token", s.getAuthorizationToken)
+	s.handle(mux, "GET", "/v1/repository", s.describeRepository)
+	s.handle(mux, "POST", "/v1/package", s.putPackageOriginConfiguration)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Seed adds repositories, replacing any with the same name.
+func (s *Server) Seed(repositories ...Repository) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range repositories {
+		r := repositories[i]
+		s.repositories[r.Name] = &r
+	}
+}
+
+// AddPackage adds a package to a repository, creating the repository if needed.
+func (s *Server) AddPackage(repository string, p Package) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.repositories[repository]
+	if !ok {
+		r = &Repository{Name: repository}
+		s.repositories[repository] = r
+	}
+	r.Packages = append(r.Packages, p)
+}
+
+// Version returns a copy of a seeded version, as updated since, and whether it exists.
+func (s *Server) Version(repository, format, namespace, pack, version string) (Version, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.repositories[repository]
+	if !ok {
+		return Version{}, false
+	}
+	for _, p := range r.Packages {
+		if p.Format != format || p.Namespace != namespace || p.Name != pack {
+			continue
+		}
+		for _, v := range p.Versions {
+			if v.Version == version {
+				return v, true
+			}
+		}
+	}
+	return Version{}, false
+}
+
+// RemoveRepository deletes a repository and everything in it.
+func (s *Server) RemoveRepository(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.repositories, name)
+}
+
+// apiError is how CodeArtifact reports failures, which the SDKs decode from the x-amzn-ErrorType header.
+type apiError struct {
+	status  int
+	kind    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.kind + ": " + e.message
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &apiError{http.StatusNotFound, "ResourceNotFoundException", fmt.Sprintf(format, args...)}
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &apiError{http.StatusBadRequest, "ValidationException", fmt.Sprintf(format, args...)}
+}
+
+// handle routes one operation, checking the domain and writing the handler's result as JSON.
+func (s *Server) handle(mux *http.ServeMux, method, path string, handler func(r *http.Request) (interface{}, error)) {
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		var body interface{}
+		var err error
+		switch {
+		case r.Method != method:
+			err = &apiError{http.StatusMethodNotAllowed, "ValidationException", "unsupported method " + r.Method}
+		case r.URL.Query().Get("domain") != s.Domain:
+			err = notFound("domain %s not found", r.URL.Query().Get("domain"))
+		case r.URL.Query().Get("domain-owner") != "" && r.URL.Query().Get("domain-owner") != s.Owner:
+			err = notFound("domain %s not found in account %s", s.Domain, r.URL.Query().Get("domain-owner"))
+		default:
+			s.mu.Lock()
+			body, err = handler(r)
+			s.mu.Unlock()
+		}
+
+		if err == nil {
+			if a, ok := body.(assetContent); ok {
+				w.Header().Set("Content-Type", "application/octet-stream")
+				w.Header().Set("X-AssetName", a.name)
+				w.Header().Set("X-PackageVersion", a.version)
+				w.Header().Set("X-PackageVersionRevision", a.revision)
+				_, _ = w.Write(a.content)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err != nil {
+			e, ok := err.(*apiError)
+			if !ok {
+				e = &apiError{http.StatusInternalServerError, "InternalServerException", err.Error()}
+			}
+			w.Header().Set("x-amzn-ErrorType", e.kind)
+			w.WriteHeader(e.status)
+			_ = json.NewEncoder(w).Encode(map[string]string{"message": e.message})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(body)
+	})
+}
+
+// page returns the bounds of the page of n items the request asks for, and the token for the next page.
+func (s *Server) page(r *http.Request, n int) (int, int, *string, error) {
+	start := 0
+	if token := r.URL.Query().Get("next-token"); token != "" {
+		var err error
+		start, err = strconv.Atoi(strings.TrimPrefix(token, "offset-"))
+		if err != nil || start > n {
+			return 0, 0, nil, invalid("invalid next-token %q", token)
+		}
+	}
+	size := s.PageSize
+	if max, err := strconv.Atoi(r.URL.Query().Get("max-results")); err == nil && max > 0 && (size <= 0 || max < size) {
+		size = max
+	}
+	end := n
+	if size > 0 && start+size < n {
+		end = start + size
+	}
+	if end == n {
+		return start, end, nil, nil
+	}
+	next := "offset-" + strconv.Itoa(end)
+	return start, end, &next, nil
+}
+
+func (s *Server) repository(r *http.Request) (*Repository, error) {
+	name := r.URL.Query().Get("repository")
+	repository, ok := s.repositories[name]
+	if !ok {
+		return nil, notFound("repository %s not found", name)
+	}
+	return repository, nil
+}
+
+func (s *Server) pack(r *http.Request) (*Package, error) {
+	repository, err := s.repository(r)
+	if err != nil {
+		return nil, err
+	}
+	return findPackage(repository, r)
+}
+
+func findPackage(repository *Repository, r *http.Request) (*Package, error) {
+	q := r.URL.Query()
+	for i := range repository.Packages {
+		p := &repository.Packages[i]
+		if p.Format == q.Get("format") && p.Namespace == q.Get("namespace") && p.Name == q.Get("package") {
+			return p, nil
+		}
+	}
+	return nil, notFound("package %s not found in %s", q.Get("package"), repository.Name)
+}
+
+func (s *Server) version(r *http.Request) (*Package, *Version, error) {
+	p, err := s.pack(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range p.Versions {
+		if p.Versions[i].Version == r.URL.Query().Get("version") {
+			return p, &p.Versions[i], nil
+		}
+	}
+	return nil, nil, notFound("version %s of %s not found", r.URL.Query().Get("version"), p.Name)
+}
+
+// optional leaves blank strings out of responses, as CodeArtifact does.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func (s *Server) listRepositories(r *http.Request) (interface{}, error) {
+	names := make([]string, 0, len(s.repositories))
+	for name := range s.repositories {
+		if strings.HasPrefix(name, r.URL.Query().Get("repository-prefix")) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	start, end, next, err := s.page(r, len(names))
+	if err != nil {
+		return nil, err
+	}
+
+	type summary struct {
+		AdministratorAccount string  `json:"administratorAccount"`
+		Arn                  string  `json:"arn"`
+		Description          *string `json:"description,omitempty"`
+		DomainName           string  `json:"domainName"`
+		DomainOwner          string  `json:"domainOwner"`
+		Name                 string  `json:"name"`
+	}
+	repositories := make([]summary, 0, end-start)
+	for _, name := range names[start:end] {
+		repositories = append(repositories, summary{
+			AdministratorAccount: s.Owner,
+			Arn:                  fmt.Sprintf("arn:aws:codeartifact:us-east-1:%s:repository/%s/%s", s.Owner, s.Domain, name),
+			Description:          optional(s.repositories[name].Description),
+			DomainName:           s.Domain,
+			DomainOwner:          s.Owner,
+			Name:                 name,
+		})
+	}
+	return map[string]interface{}{"repositories": repositories, "nextToken": next}, nil
+}
+
+func (s *Server) listPackages(r *http.Request) (interface{}, error) {
+	repository, err := s.repository(r)
+	if err != nil {
+		return nil, err
+	}
+	q := r.URL.Query()
+
+	type summary struct {
+		Format              string                 `json:"format"`
+		Namespace           *string                `json:"namespace,omitempty"`
+		Package             string                 `json:"package"`
+		OriginConfiguration map[string]interface{} `json:"originConfiguration"`
+	}
+	matches := make([]summary, 0)
+	for _, p := range repository.Packages {
+		if q.Get("format") != "" && p.Format != q.Get("format") {
+			continue
+		}
+		if q.Get("namespace") != "" && p.Namespace != q.Get("namespace") {
+			continue
+		}
+		if !strings.HasPrefix(p.Name, q.Get("package-prefix")) {
+			continue
+		}
+		matches = append(matches, summary{Format: p.Format, Namespace: optional(p.Namespace), Package: p.Name, OriginConfiguration: p.originConfiguration()})
+	}
+	start, end, next, err := s.page(r, len(matches))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"packages": matches[start:end], "nextToken": next}, nil
+}
+
+func (s *Server) listPackageVersions(r *http.Request) (interface{}, error) {
+	p, err := s.pack(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type summary struct {
+		Revision string                 `json:"revision"`
+		Status   string                 `json:"status"`
+		Version  string                 `json:"version"`
+		Origin   map[string]interface{} `json:"origin"`
+	}
+	versions := make([]summary, 0, len(p.Versions))
+	for _, v := range p.Versions {
+		if status := r.URL.Query().Get("status"); status != "" && v.Status != status {
+			continue
+		}
+		versions = append(versions, summary{Revision: v.Revision, Status: v.Status, Version: v.Version, Origin: origin(r, v)})
+	}
+	start, end, next, err := s.page(r, len(versions))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"format":    p.Format,
+		"namespace": optional(p.Namespace),
+		"package":   p.Name,
+		"versions":  versions[start:end],
+		"nextToken": next,
+	}, nil
+}
+
+func (s *Server) describePackageVersion(r *http.Request) (interface{}, error) {
+	p, v, err := s.version(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type license struct {
+		Name string `json:"name"`
+	}
+	licenses := make([]license, 0, len(v.Licenses))
+	for _, l := range v.Licenses {
+		licenses = append(licenses, license{Name: l})
+	}
+	description := map[string]interface{}{
+		"format":               p.Format,
+		"namespace":            optional(p.Namespace),
+		"packageName":          p.Name,
+		"version":              v.Version,
+		"revision":             v.Revision,
+		"status":               v.Status,
+		"displayName":          optional(v.DisplayName),
+		"summary":              optional(v.Summary),
+		"homePage":             optional(v.HomePage),
+		"sourceCodeRepository": optional(v.SourceCodeRepository),
+		"licenses":             licenses,
+	}
+	if !v.Published.IsZero() {
+		// REST JSON timestamps are epoch seconds
+		description["publishedTime"] = float64(v.Published.UnixNano()) / 1e9
+	}
+	return map[string]interface{}{"packageVersion": description}, nil
+}
+
+type versionError struct {
+	ErrorCode    string `json:"errorCode"`
+	ErrorMessage string `json:"errorMessage"`
+}
+
+type versionInfo struct {
+	Revision string `json:"revision"`
+	Status   string `json:"status"`
+}
+
+// settable are the statuses UpdatePackageVersionsStatus accepts, and changeable those it can move a version from.
+var settable = map[string]bool{"Published": true, "Unlisted": true, "Archived": true}
+var changeable = map[string]bool{"Published": true, "Unlisted": true, "Archived": true, "Unfinished": true}
+
+func (s *Server) updatePackageVersionsStatus(r *http.Request) (interface{}, error) {
+	p, err := s.pack(r)
+	if err != nil {
+		return nil, err
+	}
+	body := struct {
+		Versions       []string `json:"versions"`
+		TargetStatus   string   `json:"targetStatus"`
+		ExpectedStatus string   `json:"expectedStatus"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, invalid("%v", err)
+	}
+	if !settable[body.TargetStatus] {
+		return nil, invalid("invalid target status %q", body.TargetStatus)
+	}
+
+	successful := make(map[string]versionInfo)
+	failed := make(map[string]versionError)
+	for _, version := range body.Versions {
+		var found *Version
+		for i := range p.Versions {
+			if p.Versions[i].Version == version {
+				found = &p.Versions[i]
+			}
+		}
+		switch {
+		case found == nil:
+			failed[version] = versionError{"NOT_FOUND", "version " + version + " not found"}
+		case body.ExpectedStatus != "" && found.Status != body.ExpectedStatus:
+			failed[version] = versionError{"MISMATCHED_STATUS", "version " + version + " is " + found.Status}
+		case !changeable[found.Status]:
+			failed[version] = versionError{"NOT_ALLOWED", "version " + version + " is " + found.Status}
+		default:
+			found.Status = body.TargetStatus
+			successful[version] = versionInfo{Revision: found.Revision, Status: found.Status}
+		}
+	}
+	return map[string]interface{}{"successfulVersions": successful, "failedVersions": failed}, nil
+}
+
+// versionsRequest is the body of the bulk version operations.
+type versionsRequest struct {
+	Versions       []string `json:"versions"`
+	ExpectedStatus string   `json:"expectedStatus"`
+}
+
+func (s *Server) disposePackageVersions(r *http.Request) (interface{}, error) {
+	p, err := s.pack(r)
+	if err != nil {
+		return nil, err
+	}
+	body := versionsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, invalid("%v", err)
+	}
+
+	successful := make(map[string]versionInfo)
+	failed := make(map[string]versionError)
+	for _, version := range body.Versions {
+		var found *Version
+		for i := range p.Versions {
+			if p.Versions[i].Version == version {
+				found = &p.Versions[i]
+			}
+		}
+		switch {
+		case found == nil:
+			failed[version] = versionError{"NOT_FOUND", "version " + version + " not found"}
+		case body.ExpectedStatus != "" && found.Status != body.ExpectedStatus:
+			failed[version] = versionError{"MISMATCHED_STATUS", "version " + version + " is " + found.Status}
+		case found.Status == "Disposed":
+			failed[version] = versionError{"NOT_ALLOWED", "version " + version + " is already disposed"}
+		default:
+			found.Status = "Disposed"
+			successful[version] = versionInfo{Revision: found.Revision, Status: found.Status}
+		}
+	}
+	return map[string]interface{}{"successfulVersions": successful, "failedVersions": failed}, nil
+}
+
+func (s *Server) deletePackageVersions(r *http.Request) (interface{}, error) {
+	p, err := s.pack(r)
+	if err != nil {
+		return nil, err
+	}
+	body := versionsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, invalid("%v", err)
+	}
+
+	successful := make(map[string]versionInfo)
+	failed := make(map[string]versionError)
+	for _, version := range body.Versions {
+		kept := make([]Version, 0, len(p.Versions))
+		var found *Version
+		for _, v := range p.Versions {
+			if v.Version == version && found == nil {
+				v := v
+				found = &v
+				continue
+			}
+			kept = append(kept, v)
+		}
+		switch {
+		case found == nil:
+			failed[version] = versionError{"NOT_FOUND", "version " + version + " not found"}
+		case body.ExpectedStatus != "" && found.Status != body.ExpectedStatus:
+			failed[version] = versionError{"MISMATCHED_STATUS", "version " + version + " is " + found.Status}
+		default:
+			p.Versions = kept
+			successful[version] = versionInfo{Revision: found.Revision, Status: "Deleted"}
+		}
+	}
+	return map[string]interface{}{"successfulVersions": successful, "failedVersions": failed}, nil
+}
+
+func (s *Server) copyPackageVersions(r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	source, ok := s.repositories[q.Get("source-repository")]
+	if !ok {
+		return nil, notFound("repository %s not found", q.Get("source-repository"))
+	}
+	destination, ok := s.repositories[q.Get("destination-repository")]
+	if !ok {
+		return nil, notFound("repository %s not found", q.Get("destination-repository"))
+	}
+	p, err := findPackage(source, r)
+	if err != nil {
+		return nil, err
+	}
+	body := struct {
+		Versions       []string `json:"versions"`
+		AllowOverwrite bool     `json:"allowOverwrite"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, invalid("%v", err)
+	}
+
+	target, err := findPackage(destination, r)
+	if err != nil {
+		destination.Packages = append(destination.Packages, Package{Format: p.Format, Namespace: p.Namespace, Name: p.Name})
+		target = &destination.Packages[len(destination.Packages)-1]
+	}
+
+	successful := make(map[string]versionInfo)
+	failed := make(map[string]versionError)
+	for _, version := range body.Versions {
+		var found, existing *Version
+		for i := range p.Versions {
+			if p.Versions[i].Version == version {
+				found = &p.Versions[i]
+			}
+		}
+		for i := range target.Versions {
+			if target.Versions[i].Version == version {
+				existing = &target.Versions[i]
+			}
+		}
+		switch {
+		case found == nil:
+			failed[version] = versionError{"NOT_FOUND", "version " + version + " not found"}
+		case existing != nil && !body.AllowOverwrite:
+			failed[version] = versionError{"ALREADY_EXISTS", "version " + version + " already exists in " + destination.Name}
+		case existing != nil:
+			*existing = *found
+			successful[version] = versionInfo{Revision: found.Revision, Status: found.Status}
+		default:
+			target.Versions = append(target.Versions, *found)
+			successful[version] = versionInfo{Revision: found.Revision, Status: found.Status}
+		}
+	}
+	return map[string]interface{}{"successfulVersions": successful, "failedVersions": failed}, nil
+}
+
+func (s *Server) listPackageVersionAssets(r *http.Request) (interface{}, error) {
+	p, v, err := s.version(r)
+	if err != nil {
+		return nil, err
+	}
+
+	type summary struct {
+		Name   string            `json:"name"`
+		Size   int               `json:"size"`
+		Hashes map[string]string `json:"hashes"`
+	}
+	assets := make([]summary, 0, len(v.Assets))
+	for _, a := range v.Assets {
+		assets = append(assets, summary{Name: a.Name, Size: len(a.Content), Hashes: a.hashes()})
+	}
+	start, end, next, err := s.page(r, len(assets))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"format":          p.Format,
+		"namespace":       optional(p.Namespace),
+		"package":         p.Name,
+		"version":         v.Version,
+		"versionRevision": v.Revision,
+		"assets":          assets[start:end],
+		"nextToken":       next,
+	}, nil
+}
+
+// assetContent is written as the raw response body, as GetPackageVersionAsset's is.
+type assetContent struct {
+	name, version, revision string
+	content                 []byte
+}
+
+func (s *Server) getPackageVersionAsset(r *http.Request) (interface{}, error) {
+	_, v, err := s.version(r)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range v.Assets {
+		if a.Name == r.URL.Query().Get("asset") {
+			return assetContent{name: a.Name, version: v.Version, revision: v.Revision, content: a.Content}, nil
+		}
+	}
+	return nil, notFound("asset %s of %s not found", r.URL.Query().Get("asset"), v.Version)
+}
+
+func (s *Server) getPackageVersionReadme(r *http.Request) (interface{}, error) {
+	p, v, err := s.version(r)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"format":          p.Format,
+		"namespace":       optional(p.Namespace),
+		"package":         p.Name,
+		"version":         v.Version,
+		"versionRevision": v.Revision,
+		"readme":          optional(v.Readme),
+	}, nil
+}
+
+var formats = map[string]bool{"npm": true, "pypi": true, "maven": true, "nuget": true, "generic": true}
+
+func (s *Server) getRepositoryEndpoint(r *http.Request) (interface{}, error) {
+	repository, err := s.repository(r)
+	if err != nil {
+		return nil, err
+	}
+	format := r.URL.Query().Get("format")
+	if !formats[format] {
+		return nil, invalid("invalid format %q", format)
+	}
+	endpoint := fmt.Sprintf("https://%s-%s.d.codeartifact.us-east-1.amazonaws.com/%s/%s/", s.Domain, s.Owner, format, repository.Name)
+	return map[string]interface{}{"repositoryEndpoint": endpoint}, nil
+}
+
+// getAuthorizationToken issues tokens that name their duration, so tests can see what was asked for.
+func (s *Server) getAuthorizationToken(r *http.Request) (interface{}, error) {
+	duration := int64(12 * 60 * 60)
+	if d := r.URL.Query().Get("duration"); d != "" {
+		var err error
+		if duration, err = strconv.ParseInt(d, 10, 64); err != nil {
+			return nil, invalid("%v", err)
+		}
+		if duration == 0 {
+			duration = 12 * 60 * 60
+		} else if duration < 900 || duration > 43200 {
+			return nil, invalid("duration must be 0 or between 900 and 43200 seconds")
+		}
+	}
+	return map[string]interface{}{
+		"authorizationToken": fmt.Sprintf("token-%s-%d", s.Domain, duration),
+		"expiration":         time.Now().Add(time.Duration(duration) * time.Second).Unix(),
+	}, nil
+}
+
+// origin is where a version entered the domain: through an external connection, or published to the repository
+// it is listed in.
+func origin(r *http.Request, v Version) map[string]interface{} {
+	if v.ExternalConnection != "" {
+		return map[string]interface{}{
+			"domainEntryPoint": map[string]string{"externalConnectionName": v.ExternalConnection},
+			"originType":       "EXTERNAL",
+		}
+	}
+	return map[string]interface{}{
+		"domainEntryPoint": map[string]string{"repositoryName": r.URL.Query().Get("repository")},
+		"originType":       "INTERNAL",
+	}
+}
+
+func (s *Server) describeRepository(r *http.Request) (interface{}, error) {
+	repository, err := s.repository(r)
+	if err != nil {
+		return nil, err
+	}
+	upstreams := make([]map[string]string, 0, len(repository.Upstreams))
+	for _, name := range repository.Upstreams {
+		upstreams = append(upstreams, map[string]string{"repositoryName": name})
+	}
+	connections := make([]map[string]string, 0, len(repository.ExternalConnections))
+	for _, name := range repository.ExternalConnections {
+		connections = append(connections, map[string]string{"externalConnectionName": name, "status": "Available"})
+	}
+	return map[string]interface{}{"repository": map[string]interface{}{
+		"name":                 repository.Name,
+		"domainName":           s.Domain,
+		"domainOwner":          s.Owner,
+		"administratorAccount": s.Owner,
+		"arn":                  fmt.Sprintf("arn:aws:codeartifact:us-east-1:%s:repository/%s/%s", s.Owner, s.Domain, repository.Name),
+		"description":          optional(repository.Description),
+		"upstreams":            upstreams,
+		"externalConnections":  connections,
+	}}, nil
+}
+
+var restrictions = map[string]bool{"ALLOW": true, "BLOCK": true}
+
+func (s *Server) putPackageOriginConfiguration(r *http.Request) (interface{}, error) {
+	p, err := s.pack(r)
+	if err != nil {
+		return nil, err
+	}
+	body := struct {
+		Restrictions struct {
+			Publish  string `json:"publish"`
+			Upstream string `json:"upstream"`
+		} `json:"restrictions"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, invalid("%v", err)
+	}
+	if !restrictions[body.Restrictions.Publish] || !restrictions[body.Restrictions.Upstream] {
+		return nil, invalid("publish and upstream must be ALLOW or BLOCK")
+	}
+	p.Publish = body.Restrictions.Publish
+	p.Upstream = body.Restrictions.Upstream
+	return map[string]interface{}{"originConfiguration": p.originConfiguration()}, nil
+}
+
+// Package returns a copy of a seeded package, as updated since, and whether it exists.
+func (s *Server) Package(repository, format, namespace, name string) (Package, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.repositories[repository]
+	if !ok {
+		return Package{}, false
+	}
+	for _, p := range r.Packages {
+		if p.Format == format && p.Namespace == namespace && p.Name == name {
+			return p, true
+		}
+	}
+	return Package{}, false
+}
@@ -1,34 +1,168 @@
 package artifacts
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/rs/zerolog/log"
+	"time"
 )
 
 type Specification struct {
+	// Domain and Region name the single domain to import when Targets is empty
 	Domain    string
-	PageSize  int      `default:"100""`
-	Region    string   `default:"us-east-1""`
-	DbFile    string   `default:".db.artifacts"`
-	SkipRepos []string `default:"maven-central,maven-central-store"` // TODO remove before push
-	Listen    string   `default:"localhost:3000"`
-	Load      bool     `default:"false"`
-	Templates string   `default:"src/templates/"`
-}
-
-// AwsPageSize returns the page size in *int64 so satisfy aws expectations :(
-func (s *Specification) AwsPageSize() *int64 {
-	i := int64(s.PageSize)
+	PageSize  int    `default:"100"`
+	Region    string `default:"us-east-1"`
+	DbFile    string `default:".db.artifacts"`
+	Listen    string `default:"localhost:3000"`
+	Load      bool   `default:"false"`
+	Templates string `default:"src/templates/"`
+	// Endpoint overrides the CodeArtifact API URL for targets that don't set their own
+	Endpoint string
+	// Targets lists the CodeArtifact domains to import, possibly across accounts and regions
+	Targets ImportTargets
+	// Importers configures every source to import side by side. When empty, Targets are imported.
+	Importers ImporterConfigs
+	// Repos and SkipRepos are glob patterns. A repository is imported if it matches Repos (or Repos is empty)
+	// and doesn't match SkipRepos.
+	Repos          []string
+	SkipRepos      []string
+	PackageFilters PackageFilters
+	// Retries is how many times a failing CodeArtifact call is attempted before the failure is reported
+	Retries      int           `default:"3"`
+	RetryBackoff time.Duration `default:"1s"`
+	// FailureThreshold is the number of failed packages after which an import run gives up. 0 never gives up.
+	FailureThreshold int `default:"20"`
+	// Reconcile moves stored versions that a complete crawl of their repository no longer finds to ReconcileStatus
+	Reconcile       bool   `default:"true"`
+	ReconcileStatus Status `default:"Deleted"`
+	// Fresh ignores the checkpoint left by an interrupted CodeArtifact import, crawling from the start instead
+	Fresh bool `default:"false"`
+	// Describe fetches publish times and metadata for each version, DescribeConcurrency versions at a time
+	Describe            bool `default:"true"`
+	DescribeConcurrency int  `default:"4"`
+	// SnsCertificate is a PEM file with the certificate SNS signs event deliveries with, and SnsTopicArns the
+	// topics deliveries may come from; without both SNS deliveries are refused. EventsApiKey is the X-Api-Key an
+	// EventBridge API destination must send; without it direct deliveries are refused.
+	SnsCertificate string
+	SnsTopicArns   []string
+	EventsApiKey   string
+	// UserHeader names the request header an authenticating proxy identifies users by, which changes and
+	// repository tokens rely on. The proxy must be the only way to reach the server and must replace the header on
+	// every request, since anyone who can send it directly can act as any user.
+	UserHeader string `default:"X-Forwarded-User"`
+	// CleanupBatchSize is how many versions of a package a cleanup disposes of or deletes per call
+	CleanupBatchSize int `default:"100"`
+	// AssetCache is a directory assets downloaded through the catalog are kept in. When blank they are fetched
+	// from CodeArtifact every time.
+	AssetCache string
+	// DryRunTimeout stops a dry run started over HTTP that is still crawling after this long. 0 lets it run.
+	DryRunTimeout time.Duration `default:"30m"`
+	// TokenDuration is how long the tokens in generated client configuration last, between 15m and 12h. 0 makes
+	// them last as long as the server's own session.
+	TokenDuration time.Duration `default:"1h"`
+	// TokenRoleArn is assumed to request the tokens in generated client configuration for Domain when Targets is
+	// empty. See ImportTarget.TokenRoleArn.
+	TokenRoleArn string
+	// OriginEditors are the users, as identified by UserHeader, who may change package origin controls
+	OriginEditors []string
+}
+
+// redacted masks a secret, showing only whether it is set.
+func redacted(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "********"
+}
+
+// String writes the Specification with its secrets masked, for logging.
+func (s Specification) String() string {
+	type plain Specification
+	masked := plain(s)
+	masked.EventsApiKey = redacted(s.EventsApiKey)
+	masked.Importers = make(ImporterConfigs, len(s.Importers))
+	for i, c := range s.Importers {
+		c.Password = redacted(c.Password)
+		c.Token = redacted(c.Token)
+		masked.Importers[i] = c
+	}
+	return fmt.Sprintf("%+v", masked)
+}
+
+// ImportTarget is a CodeArtifact domain to import. RoleArn, when set, is assumed to read the domain, which is
+// how domains in other accounts are reached. TokenRoleArn, when set, is assumed instead to request the tokens
+// handed to users in client configuration, so they can be limited to reading packages rather than carry the
+// server's own permissions. Endpoint overrides the CodeArtifact API URL, e.g. for a VPC endpoint or a local
+// stand-in.
+type ImportTarget struct {
+	Domain       string
+	DomainOwner  string
+	Region       string
+	RoleArn      string
+	TokenRoleArn string `json:",omitempty"`
+	Endpoint     string
+}
+
+// ImportTargets is decoded from JSON, e.g.
+// ARTIFACTS_TARGETS='[{"Domain":"payments","DomainOwner":"111122223333","Region":"eu-west-1","RoleArn":"arn:aws:iam::111122223333:role/catalog"}]'
+type ImportTargets []ImportTarget
+
+func (t *ImportTargets) Decode(value string) error {
+	return json.Unmarshal([]byte(value), (*[]ImportTarget)(t))
+}
+
+// ImportTargets returns the configured targets, or the single Domain and Region if there are none. Without
+// either there is nothing to import. Targets without their own Region or Endpoint get the Specification's.
+func (s *Specification) ImportTargets() []ImportTarget {
+	if len(s.Targets) == 0 {
+		if s.Domain == "" {
+			return nil
+		}
+		return []ImportTarget{{Domain: s.Domain, Region: s.Region, TokenRoleArn: s.TokenRoleArn, Endpoint: s.Endpoint}}
+	}
+	targets := make([]ImportTarget, 0, len(s.Targets))
+	for _, t := range s.Targets {
+		if t.Region == "" {
+			t.Region = s.Region
+		}
+		if t.Endpoint == "" {
+			t.Endpoint = s.Endpoint
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
+
+// AwsPageSize returns the page size in *int32 so satisfy aws expectations :(
+func (s *Specification) AwsPageSize() *int32 {
+	i := int32(s.PageSize)
 	return &i
 }
 
 func (s *Specification) Skip(name string) bool {
 	for _, skip := range s.SkipRepos {
-		if name == skip {
+		if globMatch(skip, name) {
 			return true
 		}
 	}
-	return false
+	if len(s.Repos) == 0 {
+		return false
+	}
+	for _, include := range s.Repos {
+		if globMatch(include, name) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Specification) validate() error {
+	globs := append(append([]string{}, s.Repos...), s.SkipRepos...)
+	for _, f := range s.PackageFilters {
+		globs = append(globs, f.Repository)
+	}
+	return validateGlobs(globs...)
 }
 
 func LoadSpecification() (Specification, error) {
@@ -37,6 +171,10 @@ func LoadSpecification() (Specification, error) {
 	if err != nil {
 		return s, err
 	}
-	log.Info().Msgf("Found config: %+v", s)
+	err = s.validate()
+	if err != nil {
+		return s, err
+	}
+	log.Info().Msgf("Found config: %s", s)
 	return s, err
 }
@@ -0,0 +1,29 @@
+package artifacts
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSpecificationStringMasksSecrets(t *testing.T) {
+	s := Specification{
+		Domain:       "acme",
+		EventsApiKey: "events-secret",
+		Importers: ImporterConfigs{
+			{Type: "npm", Url: "https://registry.example.com", Username: "reader", Password: "npm-password"},
+			{Type: "pypi", Url: "https://pypi.example.com", Token: "pypi-token"},
+		},
+	}
+	logged := s.String()
+	for _, secret := range []string{"events-secret", "npm-password", "pypi-token"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("Expected %q to be masked, got %s", secret, logged)
+		}
+	}
+	if !strings.Contains(logged, "reader") || !strings.Contains(logged, "acme") {
+		t.Errorf("Expected the rest of the config to be logged, got %s", logged)
+	}
+	if s.Importers[0].Password != "npm-password" {
+		t.Error("Expected masking to leave the Specification alone")
+	}
+}
@@ -0,0 +1,154 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DirImporter catalogues a directory laid out as a Maven repository, like ~/.m2/repository, or as an npm
+// registry mirror, with tarballs at <package>/-/<name>-<version>.tgz. File modification times are used as
+// create times.
+type DirImporter struct {
+	Specification
+	name       string
+	repository string
+	root       string
+	format     string
+}
+
+func newDirImporter(c ImporterConfig, s Specification) (Importer, error) {
+	if c.Path == "" {
+		return nil, fmt.Errorf("dir importer needs a Path")
+	}
+	root, err := filepath.Abs(c.Path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+	if c.Format != "" && c.Format != "maven" && c.Format != "npm" {
+		return nil, fmt.Errorf("dir importer reads maven or npm layouts, not %q", c.Format)
+	}
+
+	name := c.Name
+	if name == "" {
+		name = "local"
+	}
+	repository := c.Repository
+	if repository == "" {
+		repository = filepath.Base(root)
+	}
+	return &DirImporter{Specification: s, name: name, repository: repository, root: root, format: c.Format}, nil
+}
+
+func (d *DirImporter) Name() string {
+	return d.name
+}
+
+func (d *DirImporter) Import(ctx context.Context, report *SyncReport, out chan<- Artifact) {
+	defer close(out)
+	report.count(1, 0, 0)
+
+	filters := d.PackageFilters.For(d.repository)
+	packages := make(map[PackageRef]bool)
+	err := filepath.WalkDir(d.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if report.IsAborted() {
+			return fs.SkipDir
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		artifact, ok := d.artifact(path)
+		if !ok || !filters.Matches(artifact.Format, artifact.Namespace, artifact.Package) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		artifact.CreateTime = info.ModTime()
+
+		ref := PackageRef{Namespace: artifact.Namespace, Package: artifact.Package}
+		if !packages[ref] {
+			packages[ref] = true
+			report.count(0, 1, 0)
+		}
+		out <- artifact
+		return nil
+	})
+	if err != nil {
+		out <- Artifact{DomainName: d.name, Repository: d.repository, Error: err}
+		return
+	}
+
+	if !report.IsAborted() {
+		report.Complete(Scope{DomainName: d.name, Repository: d.repository, Filters: filters})
+	}
+}
+
+// artifact recognises the file that marks a version in either layout: a Maven pom or an npm tarball.
+func (d *DirImporter) artifact(path string) (Artifact, bool) {
+	relative, err := filepath.Rel(d.root, path)
+	if err != nil {
+		return Artifact{}, false
+	}
+	parts := strings.Split(filepath.ToSlash(relative), "/")
+	file := parts[len(parts)-1]
+	artifact := Artifact{
+		Repository: d.repository,
+		DomainName: d.name,
+		Status:     Published,
+		CreateTime: time.Now(),
+	}
+
+	// <group path>/<artifactId>/<version>/<artifactId>-<version>.pom
+	if d.format != "npm" && strings.HasSuffix(file, ".pom") && len(parts) >= 4 {
+		version, artifactId := parts[len(parts)-2], parts[len(parts)-3]
+		if file == artifactId+"-"+version+".pom" {
+			artifact.ArtifactId = ArtifactId{
+				Namespace: strings.Join(parts[:len(parts)-3], "."),
+				Package:   artifactId,
+				Version:   version,
+			}
+			artifact.Format = "maven"
+			return artifact, true
+		}
+	}
+
+	// [@scope/]<name>/-/<name>-<version>.tgz
+	if d.format != "maven" && strings.HasSuffix(file, ".tgz") && len(parts) >= 3 && parts[len(parts)-2] == "-" {
+		name := parts[len(parts)-3]
+		scope := ""
+		if len(parts) >= 4 && strings.HasPrefix(parts[len(parts)-4], "@") {
+			scope = strings.TrimPrefix(parts[len(parts)-4], "@")
+		}
+		if strings.HasPrefix(file, name+"-") {
+			artifact.ArtifactId = ArtifactId{
+				Namespace: scope,
+				Package:   name,
+				Version:   strings.TrimSuffix(strings.TrimPrefix(file, name+"-"), ".tgz"),
+			}
+			artifact.Format = "npm"
+			return artifact, true
+		}
+	}
+
+	return Artifact{}, false
+}
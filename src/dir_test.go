@@ -0,0 +1,82 @@
+package artifacts
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirImporter(t *testing.T) {
+	root := t.TempDir()
+	modified := time.Date(2021, 11, 1, 9, 0, 0, 0, time.UTC)
+	for _, file := range []string{
+		"com/acme/widgets/1.0/widgets-1.0.pom",
+		"com/acme/widgets/1.0/widgets-1.0.jar",
+		"com/acme/widgets/1.1/widgets-1.1.pom",
+		"com/acme/widgets/maven-metadata-central.xml",
+		"left-pad/-/left-pad-1.3.0.tgz",
+		"@acme/client/-/client-2.0.0-beta.1.tgz",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	storage := newTestStorage(t)
+	s := Specification{Importers: ImporterConfigs{{Type: "dir", Path: root, Repository: "m2"}}}
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := LoadArtifacts(context.Background(), importers, s, storage)
+	if len(report.Failures) > 0 {
+		t.Fatalf("Unexpected failures %+v", report.Failures)
+	}
+	if report.Packages != 3 || report.Inserted != 4 {
+		t.Errorf("Expected 3 packages and 4 versions, got %+v", report)
+	}
+
+	stored, err := storage.ForRepository(Location{DomainName: "local", Repository: "m2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[ArtifactId]Artifact)
+	for _, a := range stored {
+		found[a.ArtifactId] = a
+	}
+	for id, format := range map[ArtifactId]string{
+		{Namespace: "com.acme", Package: "widgets", Version: "1.0"}:     "maven",
+		{Namespace: "com.acme", Package: "widgets", Version: "1.1"}:     "maven",
+		{Namespace: "", Package: "left-pad", Version: "1.3.0"}:          "npm",
+		{Namespace: "acme", Package: "client", Version: "2.0.0-beta.1"}: "npm",
+	} {
+		a, ok := found[id]
+		if !ok {
+			t.Errorf("Missing %+v", id)
+			continue
+		}
+		if a.Format != format || !a.CreateTime.Equal(modified) {
+			t.Errorf("Expected %s artifact created at %v, got %+v", format, modified, a)
+		}
+	}
+}
+
+func TestDirImporterFormat(t *testing.T) {
+	_, err := newDirImporter(ImporterConfig{Type: "dir", Path: t.TempDir(), Format: "pypi"}, Specification{})
+	if err == nil {
+		t.Error("Expected an unsupported format to be refused")
+	}
+	_, err = newDirImporter(ImporterConfig{Type: "dir"}, Specification{})
+	if err == nil {
+		t.Error("Expected a missing path to be refused")
+	}
+}
@@ -1,7 +1,9 @@
 package artifacts
 
 import (
-	"github.com/aws/aws-sdk-go/service/codeartifact"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact/types"
+	"strings"
 	"time"
 )
 
@@ -16,11 +18,26 @@ type Artifact struct {
 	Repository string
 	Revision   string
 	DomainName string
-	Format     string
-	Error      error    `json:"-"`
-	Problems   []string `json:",omitempty"`
-	Status     Status
-	CreateTime time.Time
+	// Account owns the domain the artifact was imported from, and Region is where the domain is
+	Account  string `json:",omitempty"`
+	Region   string `json:",omitempty"`
+	Format   string
+	Error    error    `json:"-"`
+	Problems []string `json:",omitempty"`
+	Status   Status
+	// CreateTime is when the version was published, or when it was imported if the source doesn't say
+	CreateTime           time.Time
+	DisplayName          string   `json:",omitempty"`
+	Summary              string   `json:",omitempty"`
+	Licenses             []string `json:",omitempty"`
+	HomePage             string   `json:",omitempty"`
+	SourceCodeRepository string   `json:",omitempty"`
+	// ExternalConnection names the public registry, e.g. public:maven-central, the version was cached from. It is
+	// blank for versions published to the domain.
+	ExternalConnection string `json:",omitempty"`
+
+	// done is called once the artifact has been handled by the import it came from, with whether it was stored
+	done func(stored bool)
 }
 
 // Status represents package version status. See: https://docs.aws.amazon.com/codeartifact/latest/ug/packages-overview.html#package-version-status
@@ -45,7 +62,101 @@ var AllStatuses = []Status{
 }
 
 type Package struct {
-	*codeartifact.RepositorySummary
-	*codeartifact.PackageSummary
+	*types.RepositorySummary
+	*types.PackageSummary
 	Error error
+
+	// done is called once every version of the package has been handled, with whether they were all stored
+	done func(stored bool)
+}
+
+// Location is where a copy of a version is stored. The same version can be in several repositories, and in
+// same-named domains of several accounts and regions, so the catalog keeps one record per location. Blank fields
+// match any location when looking copies up.
+type Location struct {
+	DomainName string
+	Account    string
+	Region     string
+	Repository string
+}
+
+// Location is where the artifact was imported from.
+func (a Artifact) Location() Location {
+	return Location{DomainName: a.DomainName, Account: a.Account, Region: a.Region, Repository: a.Repository}
+}
+
+func (l Location) matches(a Artifact) bool {
+	return (l.DomainName == "" || l.DomainName == a.DomainName) &&
+		(l.Account == "" || l.Account == a.Account) &&
+		(l.Region == "" || l.Region == a.Region) &&
+		(l.Repository == "" || l.Repository == a.Repository)
+}
+
+// holds reports whether a is the copy stored at this location. Copies stored before accounts and regions were
+// recorded have none, and are held by every account and region.
+func (l Location) holds(a Artifact) bool {
+	return l.DomainName == a.DomainName && l.Repository == a.Repository &&
+		(a.Account == "" || l.Account == a.Account) &&
+		(a.Region == "" || l.Region == a.Region)
+}
+
+// legacy lists where copies of what's stored here were kept before accounts and regions were recorded.
+func (l Location) legacy() []Location {
+	legacy := make([]Location, 0, 2)
+	if l.Region != "" {
+		legacy = append(legacy, Location{DomainName: l.DomainName, Account: l.Account, Repository: l.Repository})
+	}
+	if l.Account != "" {
+		legacy = append(legacy, Location{DomainName: l.DomainName, Repository: l.Repository})
+	}
+	return legacy
+}
+
+// LocatedRef writes the artifact as namespace:package:version@domain/repository, which names this copy of the
+// version when it is in several repositories.
+func (a Artifact) LocatedRef() string {
+	return a.Ref() + "@" + a.DomainName + "/" + a.Repository
+}
+
+// Ref writes the id as namespace:package:version, leaving out what's blank.
+func (i ArtifactId) Ref() string {
+	parts := make([]string, 0, 3)
+	for _, part := range []string{i.Namespace, i.Package, i.Version} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// ParseArtifactRef reads an id written by Ref. It splits from the right, so a ref with only two parts is a
+// package and version without a namespace.
+func ParseArtifactRef(ref string) (ArtifactId, error) {
+	parts := strings.Split(ref, ":")
+	if len(parts) < 2 {
+		return ArtifactId{}, fmt.Errorf("%q isn't namespace:package:version", ref)
+	}
+	id := ArtifactId{
+		Namespace: strings.Join(parts[:len(parts)-2], ":"),
+		Package:   parts[len(parts)-2],
+		Version:   parts[len(parts)-1],
+	}
+	if id.Package == "" || id.Version == "" {
+		return ArtifactId{}, fmt.Errorf("%q needs a package and version", ref)
+	}
+	return id, nil
+}
+
+// ParseLocatedRef reads a ref written by Ref or LocatedRef. The location is blank for a plain ref.
+func ParseLocatedRef(ref string) (ArtifactId, Location, error) {
+	at := Location{}
+	if i := strings.LastIndex(ref, "@"); i >= 0 && !strings.Contains(ref[i:], ":") {
+		parts := strings.Split(ref[i+1:], "/")
+		if len(parts) == 2 {
+			at.DomainName, at.Repository = parts[0], parts[1]
+			ref = ref[:i]
+		}
+	}
+	id, err := ParseArtifactRef(ref)
+	return id, at, err
 }
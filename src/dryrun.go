@@ -0,0 +1,212 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ImportDiff is how an import would change the catalog: artifacts it would add, stored artifacts whose status
+// it would change, and those reconciliation would mark as gone.
+type ImportDiff struct {
+	Report    *SyncReport
+	Added     []Artifact
+	Changed   []StatusChange
+	Removed   []Reconciliation
+	Unchanged int
+}
+
+// StatusChange is a stored artifact the source now lists with a different status.
+type StatusChange struct {
+	ArtifactId
+	DomainName string
+	Repository string
+	From       Status
+	To         Status
+}
+
+// DryRun runs the importers like LoadArtifacts, but compares what they find with the catalog instead of
+// writing to it.
+func DryRun(ctx context.Context, importers []Importer, s Specification, session *BoltStorage) *ImportDiff {
+	sink := diffSink{
+		session: session,
+		diff: &ImportDiff{
+			Added:   make([]Artifact, 0),
+			Changed: make([]StatusChange, 0),
+			Removed: make([]Reconciliation, 0),
+		},
+	}
+	sink.diff.Report = runImporters(ctx, importers, s, sink, NewSyncReport(s.FailureThreshold))
+	return sink.diff
+}
+
+// DryRunJob is a dry run started on behalf of User. Diff is set once it has Finished.
+type DryRunJob struct {
+	Id       string
+	User     string
+	Started  time.Time
+	Finished time.Time
+	Diff     *ImportDiff
+}
+
+var ErrDryRunNotFound = errors.New("no such dry run")
+
+// dryRunJobs runs dry runs in the background, one at a time, and keeps their results for a day.
+type dryRunJobs struct {
+	importers []Importer
+	s         Specification
+	session   *BoltStorage
+	mutex     sync.Mutex
+	jobs      map[string]*DryRunJob
+	running   *DryRunJob
+}
+
+func newDryRunJobs(importers []Importer, s Specification, session *BoltStorage) *dryRunJobs {
+	return &dryRunJobs{importers: importers, s: s, session: session, jobs: make(map[string]*DryRunJob)}
+}
+
+// start begins a dry run for user, stopped after s.DryRunTimeout if that is set, or returns the one already
+// running.
+func (j *dryRunJobs) start(user string) (DryRunJob, error) {
+	if user == "" {
+		return DryRunJob{}, errNoUser
+	}
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	if j.running != nil {
+		return *j.running, nil
+	}
+	for id, job := range j.jobs {
+		if time.Since(job.Finished) > 24*time.Hour {
+			delete(j.jobs, id)
+		}
+	}
+
+	job := &DryRunJob{Id: uuid.NewString(), User: user, Started: time.Now()}
+	j.jobs[job.Id] = job
+	j.running = job
+	log.Info().Str("dryRun", job.Id).Str("user", user).Msg("Started dry run")
+	go func() {
+		ctx, cancel := context.Background(), context.CancelFunc(func() {})
+		if j.s.DryRunTimeout > 0 {
+			ctx, cancel = context.WithTimeout(ctx, j.s.DryRunTimeout)
+		}
+		defer cancel()
+		diff := DryRun(ctx, j.importers, j.s, j.session)
+		j.mutex.Lock()
+		defer j.mutex.Unlock()
+		job.Diff = diff
+		job.Finished = time.Now()
+		j.running = nil
+		log.Info().Str("dryRun", job.Id).Dur("duration", job.Finished.Sub(job.Started)).Msg("Finished dry run")
+	}()
+	return *job, nil
+}
+
+// get returns a copy of the dry run with id.
+func (j *dryRunJobs) get(id string) (DryRunJob, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return DryRunJob{}, ErrDryRunNotFound
+	}
+	return *job, nil
+}
+
+type diffSink struct {
+	session *BoltStorage
+	diff    *ImportDiff
+}
+
+func (d diffSink) insert(batch []Artifact, report *SyncReport) {
+	for _, a := range batch {
+		if a.Error != nil {
+			report.Fail(a.DomainName, a.Repository, a.Namespace, a.Package, a.Error)
+			continue
+		}
+		if problems := a.validate(); len(problems) > 0 {
+			report.Fail(a.DomainName, a.Repository, a.Namespace, a.Package, fmt.Errorf("version %s: %s", a.Version, strings.Join(problems, ", ")))
+			continue
+		}
+
+		stored, err := d.session.Get(a.ArtifactId, a.Location())
+		// the copy may have been stored before accounts and regions were recorded
+		for _, legacy := range a.Location().legacy() {
+			if err != nil || stored != nil {
+				break
+			}
+			stored, err = d.session.Get(a.ArtifactId, legacy)
+		}
+		if err != nil {
+			report.Fail(a.DomainName, a.Repository, a.Namespace, a.Package, err)
+			continue
+		}
+		switch {
+		case stored == nil:
+			d.diff.Added = append(d.diff.Added, a)
+		case stored.Status != a.Status:
+			d.diff.Changed = append(d.diff.Changed, StatusChange{
+				ArtifactId: a.ArtifactId,
+				DomainName: a.DomainName,
+				Repository: a.Repository,
+				From:       stored.Status,
+				To:         a.Status,
+			})
+		default:
+			d.diff.Unchanged++
+		}
+	}
+}
+
+func (d diffSink) reconcile(status Status, scope Scope, seen map[ArtifactId]bool, report *SyncReport) {
+	missing, err := missingArtifacts(d.session, status, scope, seen)
+	if err != nil {
+		report.Fail(scope.DomainName, scope.Repository, "", "", fmt.Errorf("reconciling: %w", err))
+		return
+	}
+	if len(missing) == 0 {
+		return
+	}
+	d.diff.Removed = append(d.diff.Removed, Reconciliation{
+		DomainName: scope.DomainName,
+		Repository: scope.Repository,
+		Status:     status,
+		Artifacts:  artifactIds(missing),
+	})
+}
+
+// Summary describes the diff for people, one artifact per line.
+func (d *ImportDiff) Summary() string {
+	removed := 0
+	for _, r := range d.Removed {
+		removed += len(r.Artifacts)
+	}
+
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "%d repositories, %d packages: %d new, %d status changes, %d removed, %d unchanged, %d failed packages\n",
+		d.Report.Repositories, d.Report.Packages, len(d.Added), len(d.Changed), removed, d.Unchanged, len(d.Report.Failures))
+	if d.Report.Aborted {
+		fmt.Fprintf(b, "aborted: %s\n", d.Report.AbortReason)
+	}
+	for _, a := range d.Added {
+		fmt.Fprintf(b, "+ %s/%s %s %s\n", a.DomainName, a.Repository, a.Ref(), a.Status)
+	}
+	for _, c := range d.Changed {
+		fmt.Fprintf(b, "~ %s/%s %s %s -> %s\n", c.DomainName, c.Repository, c.Ref(), c.From, c.To)
+	}
+	for _, r := range d.Removed {
+		for _, id := range r.Artifacts {
+			fmt.Fprintf(b, "- %s/%s %s -> %s\n", r.DomainName, r.Repository, id.Ref(), r.Status)
+		}
+	}
+	for _, f := range d.Report.Failures {
+		fmt.Fprintf(b, "! %s/%s %s: %s\n", f.DomainName, f.Repository, ArtifactId{Namespace: f.Namespace, Package: f.Package}.Ref(), strings.Join(f.Errors, "; "))
+	}
+	return b.String()
+}
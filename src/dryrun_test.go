@@ -0,0 +1,127 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDryRun(t *testing.T) {
+	storage := newTestStorage(t)
+	widget := func(version string, status Status) Artifact {
+		return Artifact{
+			ArtifactId: ArtifactId{Namespace: "com.acme", Package: "widget", Version: version},
+			Repository: "internal",
+			DomainName: "acme",
+			Format:     "maven",
+			Status:     status,
+			CreateTime: time.Now(),
+		}
+	}
+	_, err := storage.Insert(widget("0.9.0", Published), widget("1.0.0", Published), widget("1.1.0", Published))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importers := []Importer{
+		staticImporter{
+			artifacts: []Artifact{widget("1.0.0", Unlisted), widget("1.1.0", Published), widget("1.2.0", Published)},
+			scope:     Scope{DomainName: "acme", Repository: "internal"},
+		},
+		staticImporter{
+			artifacts: []Artifact{{DomainName: "acme", Repository: "release", Error: errors.New("boom")}},
+			scope:     Scope{DomainName: "acme", Repository: "release"},
+		},
+	}
+	s := Specification{PageSize: 10, Reconcile: true, ReconcileStatus: Deleted, UserHeader: "X-Forwarded-User", DryRunTimeout: time.Minute}
+	diff := DryRun(context.Background(), importers, s, storage)
+
+	if len(diff.Added) != 1 || diff.Added[0].Version != "1.2.0" {
+		t.Errorf("Expected 1.2.0 to be new: %+v", diff.Added)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].Version != "1.0.0" || diff.Changed[0].From != Published || diff.Changed[0].To != Unlisted {
+		t.Errorf("Expected 1.0.0 to be unlisted: %+v", diff.Changed)
+	}
+	if len(diff.Removed) != 1 || len(diff.Removed[0].Artifacts) != 1 || diff.Removed[0].Artifacts[0].Version != "0.9.0" {
+		t.Errorf("Expected 0.9.0 to be removed: %+v", diff.Removed)
+	}
+	if diff.Unchanged != 1 || len(diff.Report.Failures) != 1 {
+		t.Errorf("Expected 1.1.0 unchanged and the release failure reported: %+v", diff)
+	}
+
+	stored, err := onlyCopy(storage, widget("1.0.0", Published).ArtifactId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != Published {
+		t.Errorf("Dry run changed the catalog: %+v", stored)
+	}
+	if missing, _ := onlyCopy(storage, widget("1.2.0", Published).ArtifactId); missing != nil {
+		t.Errorf("Dry run inserted %+v", missing)
+	}
+
+	summary := diff.Summary()
+	for _, line := range []string{
+		"+ acme/internal com.acme:widget:1.2.0 Published",
+		"~ acme/internal com.acme:widget:1.0.0 Published -> Unlisted",
+		"- acme/internal com.acme:widget:0.9.0 -> Deleted",
+		"! acme/release : boom",
+	} {
+		if !strings.Contains(summary, line+"\n") {
+			t.Errorf("Expected %q in summary:\n%s", line, summary)
+		}
+	}
+
+	router := initRouting(storage, DryRunRoutes(importers, s, storage))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("POST", "/dry-run", nil))
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a dry run without a user to be refused, got %d", response.Code)
+	}
+
+	request := httptest.NewRequest("POST", "/dry-run", nil)
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Forwarded-User", "alice")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	started := DryRunJob{}
+	if err := json.NewDecoder(response.Body).Decode(&started); err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != http.StatusAccepted || started.User != "alice" || response.Header().Get("Location") != "/dry-run/"+started.Id {
+		t.Fatalf("Expected the dry run to be started for alice, got %d %+v", response.Code, started)
+	}
+
+	served := DryRunJob{}
+	for deadline := time.Now().Add(5 * time.Second); served.Diff == nil && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		request = httptest.NewRequest("GET", "/dry-run/"+started.Id, nil)
+		request.Header.Set("Content-Type", "application/json")
+		response = httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		if err := json.NewDecoder(response.Body).Decode(&served); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if served.Diff == nil || len(served.Diff.Added) != 1 || len(served.Diff.Changed) != 1 {
+		t.Fatalf("Unexpected dry run served: %+v", served)
+	}
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/dry-run/"+started.Id, nil))
+	text, _ := io.ReadAll(response.Body)
+	if !strings.Contains(string(text), "1 new, 1 status changes, 1 removed") {
+		t.Errorf("Expected a text summary, got %s", text)
+	}
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/dry-run/nope", nil))
+	if response.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown dry run to be missing, got %d", response.Code)
+	}
+}
@@ -0,0 +1,301 @@
+package artifacts
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// packageVersionEvent is the EventBridge event CodeArtifact emits whenever a package version is created, updated
+// or deleted. See: https://docs.aws.amazon.com/codeartifact/latest/ug/service-event-format-example.html
+type packageVersionEvent struct {
+	DetailType string    `json:"detail-type"`
+	Source     string    `json:"source"`
+	Region     string    `json:"region"`
+	Time       time.Time `json:"time"`
+	Detail     struct {
+		DomainName             string `json:"domainName"`
+		DomainOwner            string `json:"domainOwner"`
+		RepositoryName         string `json:"repositoryName"`
+		PackageFormat          string `json:"packageFormat"`
+		PackageNamespace       string `json:"packageNamespace"`
+		PackageName            string `json:"packageName"`
+		PackageVersion         string `json:"packageVersion"`
+		PackageVersionState    string `json:"packageVersionState"`
+		PackageVersionRevision string `json:"packageVersionRevision"`
+		OperationType          string `json:"operationType"`
+	} `json:"detail"`
+}
+
+func (e *packageVersionEvent) isPackageVersionChange() bool {
+	return e.Source == "aws.codeartifact" && e.DetailType == "CodeArtifact Package Version State Change"
+}
+
+// snsMessage is an SNS HTTPS delivery. See: https://docs.aws.amazon.com/sns/latest/dg/sns-message-and-json-formats.html
+type snsMessage struct {
+	Type             string
+	MessageId        string
+	Token            string
+	TopicArn         string
+	Subject          string
+	Message          string
+	Timestamp        string
+	SignatureVersion string
+	Signature        string
+	SubscribeURL     string
+}
+
+// stringToSign builds the canonical form of the message SNS signs, which depends on the message type.
+func (m *snsMessage) stringToSign() string {
+	fields := []string{"Message", m.Message, "MessageId", m.MessageId}
+	if m.Type == "Notification" {
+		if m.Subject != "" {
+			fields = append(fields, "Subject", m.Subject)
+		}
+	} else {
+		fields = append(fields, "SubscribeURL", m.SubscribeURL)
+	}
+	fields = append(fields, "Timestamp", m.Timestamp)
+	if m.Type != "Notification" {
+		fields = append(fields, "Token", m.Token)
+	}
+	fields = append(fields, "TopicArn", m.TopicArn, "Type", m.Type)
+	return strings.Join(fields, "\n") + "\n"
+}
+
+// snsMaxAge is how long after SNS sent a message it's accepted, so a captured delivery can't be replayed later.
+const snsMaxAge = time.Hour
+
+// current checks the message was sent within snsMaxAge of now, either way to allow for clock skew.
+func (m *snsMessage) current(now time.Time) error {
+	sent, err := time.Parse(time.RFC3339, m.Timestamp)
+	if err != nil {
+		return fmt.Errorf("bad SNS timestamp: %w", err)
+	}
+	if age := now.Sub(sent); age > snsMaxAge || age < -snsMaxAge {
+		return fmt.Errorf("SNS message sent at %s is outside the %s window", m.Timestamp, snsMaxAge)
+	}
+	return nil
+}
+
+// verify checks the message was signed by the holder of certificate. The certificate is configured locally
+// rather than fetched from the message's SigningCertURL, so a forged message can't vouch for itself.
+func (m *snsMessage) verify(certificate *x509.Certificate) error {
+	key, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("SNS certificate doesn't hold an RSA key")
+	}
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return err
+	}
+
+	signed := []byte(m.stringToSign())
+	switch m.SignatureVersion {
+	case "1":
+		digest := sha1.Sum(signed)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA1, digest[:], signature)
+	case "2":
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	default:
+		return fmt.Errorf("unknown SNS signature version %q", m.SignatureVersion)
+	}
+}
+
+// loadCertificate reads the first certificate in a PEM file.
+func loadCertificate(path string) (*x509.Certificate, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+var errEventNotStored = errors.New("the deleted version isn't in the catalog")
+
+// applyEvent records the version the event describes in the copy stored for the event's domain and repository,
+// leaving copies of the version in other repositories alone. Stored metadata is kept, since events only carry the
+// version's status and revision. A deletion of a version that was never stored there is errEventNotStored.
+func applyEvent(session *BoltStorage, event packageVersionEvent) (Artifact, error) {
+	d := event.Detail
+	id := ArtifactId{Namespace: d.PackageNamespace, Package: d.PackageName, Version: d.PackageVersion}
+	copies, err := session.Copies(id, Location{DomainName: d.DomainName, Repository: d.RepositoryName})
+	if err != nil {
+		return Artifact{}, err
+	}
+	at := Location{DomainName: d.DomainName, Account: d.DomainOwner, Region: event.Region, Repository: d.RepositoryName}
+	var stored *Artifact
+	for i, c := range copies {
+		if at.holds(c) {
+			stored = &copies[i]
+			break
+		}
+	}
+	if stored == nil && d.OperationType == "Deleted" {
+		return Artifact{ArtifactId: id, DomainName: d.DomainName, Account: d.DomainOwner, Region: event.Region, Repository: d.RepositoryName}, errEventNotStored
+	}
+
+	artifact := Artifact{ArtifactId: id, CreateTime: event.Time, DomainName: d.DomainName, Account: d.DomainOwner, Region: event.Region, Repository: d.RepositoryName}
+	if stored != nil {
+		artifact = *stored
+	}
+	// a copy stored before accounts and regions were recorded is moved to where the event says it is
+	artifact.Account, artifact.Region = d.DomainOwner, event.Region
+	artifact.Format = d.PackageFormat
+	artifact.Revision = d.PackageVersionRevision
+	artifact.Status = Status(d.PackageVersionState)
+	if d.OperationType == "Deleted" {
+		artifact.Status = Deleted
+	}
+
+	inserted, err := session.Insert(artifact)
+	if err != nil {
+		return artifact, err
+	}
+	return inserted[0], inserted[0].Error
+}
+
+// EventRoutes serves POST /events/codeartifact, which takes CodeArtifact's package version events as an
+// EventBridge API destination or an SNS HTTPS subscription delivers them, and applies them to the catalog. SNS
+// messages from topics other than s.SnsTopicArns are refused before a subscription is confirmed.
+func EventRoutes(s Specification, session *BoltStorage) (Routes, error) {
+	var certificate *x509.Certificate
+	if s.SnsCertificate != "" {
+		var err error
+		certificate, err = loadCertificate(s.SnsCertificate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	topics := make(map[string]bool, len(s.SnsTopicArns))
+	for _, arn := range s.SnsTopicArns {
+		topics[arn] = true
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	return func(r *mux.Router) {
+		r.Methods("POST").Path("/events/codeartifact").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			body, err := io.ReadAll(io.LimitReader(request.Body, 1<<20))
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if request.Header.Get("x-amz-sns-message-type") == "" {
+				key := request.Header.Get("X-Api-Key")
+				if s.EventsApiKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(s.EventsApiKey)) != 1 {
+					http.Error(writer, "Missing or wrong X-Api-Key", http.StatusForbidden)
+					return
+				}
+				handleEvent(writer, body, session)
+				return
+			}
+
+			if certificate == nil || len(topics) == 0 {
+				http.Error(writer, "SNS deliveries need SnsCertificate and SnsTopicArns to be configured", http.StatusForbidden)
+				return
+			}
+			message := snsMessage{}
+			if err := json.Unmarshal(body, &message); err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := message.verify(certificate); err != nil {
+				log.Warn().Err(err).Str("topic", message.TopicArn).Msg("Rejected SNS message")
+				http.Error(writer, "Invalid SNS signature", http.StatusForbidden)
+				return
+			}
+			if err := message.current(time.Now()); err != nil {
+				log.Warn().Err(err).Str("topic", message.TopicArn).Str("id", message.MessageId).Msg("Rejected stale SNS message")
+				http.Error(writer, "Stale SNS message", http.StatusForbidden)
+				return
+			}
+			if !topics[message.TopicArn] {
+				log.Warn().Str("topic", message.TopicArn).Msg("Rejected SNS message from an unexpected topic")
+				http.Error(writer, "Unexpected SNS topic", http.StatusForbidden)
+				return
+			}
+
+			switch message.Type {
+			case "SubscriptionConfirmation":
+				response, err := client.Get(message.SubscribeURL)
+				if err != nil {
+					http.Error(writer, err.Error(), http.StatusBadGateway)
+					return
+				}
+				_ = response.Body.Close()
+				if response.StatusCode != http.StatusOK {
+					http.Error(writer, fmt.Sprintf("Confirming subscription returned %s", response.Status), http.StatusBadGateway)
+					return
+				}
+				log.Info().Str("topic", message.TopicArn).Msg("Confirmed SNS subscription")
+				writer.WriteHeader(http.StatusNoContent)
+			case "Notification":
+				handleEvent(writer, []byte(message.Message), session)
+			default:
+				log.Info().Str("type", message.Type).Str("topic", message.TopicArn).Msg("Ignoring SNS message")
+				writer.WriteHeader(http.StatusNoContent)
+			}
+		})
+	}, nil
+}
+
+func handleEvent(writer http.ResponseWriter, body []byte, session *BoltStorage) {
+	event := packageVersionEvent{}
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !event.isPackageVersionChange() {
+		// acknowledge, so the sender doesn't retry events we have no use for
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	artifact, err := applyEvent(session, event)
+	if err == errEventNotStored {
+		log.Info().Interface("artifact", artifact.ArtifactId).Str("repository", artifact.Repository).Msg("Ignoring deletion of a version not in the catalog")
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	status := http.StatusOK
+	if err != nil {
+		artifact.populateProblems()
+		log.Error().Err(err).Interface("artifact", artifact).Msg("Failed to apply event")
+		status = http.StatusInternalServerError
+		if _, ok := err.(*ValidationError); ok {
+			status = http.StatusUnprocessableEntity
+		}
+	} else {
+		log.Info().Interface("artifact", artifact.ArtifactId).Str("status", string(artifact.Status)).Msg("Applied event")
+	}
+
+	jsonObjects, err := json.Marshal(artifact)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	_, _ = writer.Write(jsonObjects)
+}
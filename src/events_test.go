@@ -0,0 +1,201 @@
+package artifacts
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// snsSigner stands in for SNS, signing messages with a self-signed certificate written to a PEM file.
+type snsSigner struct {
+	key  *rsa.PrivateKey
+	file string
+}
+
+func newSnsSigner(t *testing.T) snsSigner {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.us-east-1.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "sns.pem")
+	err = os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snsSigner{key: key, file: file}
+}
+
+func (s snsSigner) sign(t *testing.T, m snsMessage) []byte {
+	m.SignatureVersion = "2"
+	if m.TopicArn == "" {
+		m.TopicArn = "arn:aws:sns:us-east-1:111122223333:codeartifact"
+	}
+	if m.Timestamp == "" {
+		m.Timestamp = time.Now().UTC().Format("2006-01-02T15:04:05.000Z")
+	}
+	digest := sha256.Sum256([]byte(m.stringToSign()))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(signature)
+	body, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func codeArtifactEvent(operation, state string) string {
+	return `{
+		"version": "0",
+		"detail-type": "CodeArtifact Package Version State Change",
+		"source": "aws.codeartifact",
+		"account": "111122223333",
+		"region": "us-east-1",
+		"time": "2021-11-01T09:00:00Z",
+		"detail": {
+			"domainName": "acme",
+			"domainOwner": "111122223333",
+			"repositoryName": "internal",
+			"packageFormat": "npm",
+			"packageNamespace": null,
+			"packageName": "left-pad",
+			"packageVersion": "1.3.0",
+			"packageVersionState": "` + state + `",
+			"packageVersionRevision": "REVISION",
+			"operationType": "` + operation + `"
+		}
+	}`
+}
+
+func TestEventRoutes(t *testing.T) {
+	signer := newSnsSigner(t)
+	storage := newTestStorage(t)
+	events, err := EventRoutes(Specification{SnsCertificate: signer.file, SnsTopicArns: []string{"arn:aws:sns:us-east-1:111122223333:codeartifact"}, EventsApiKey: "secret"}, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := initRouting(storage, events)
+	post := func(body []byte, header http.Header) int {
+		request := httptest.NewRequest("POST", "/events/codeartifact", bytes.NewReader(body))
+		for k, v := range header {
+			request.Header[k] = v
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response.Code
+	}
+	sns := func(messageType string) http.Header {
+		return http.Header{"X-Amz-Sns-Message-Type": {messageType}}
+	}
+	id := ArtifactId{Package: "left-pad", Version: "1.3.0"}
+
+	confirmed := false
+	subscriptions := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		confirmed = r.URL.Query().Get("Token") == "token"
+	}))
+	defer subscriptions.Close()
+	confirmation := signer.sign(t, snsMessage{
+		Type:         "SubscriptionConfirmation",
+		MessageId:    "1",
+		Token:        "token",
+		Message:      "You have chosen to subscribe",
+		SubscribeURL: subscriptions.URL + "/?Action=ConfirmSubscription&Token=token",
+	})
+	stranger := signer.sign(t, snsMessage{
+		Type:         "SubscriptionConfirmation",
+		MessageId:    "0",
+		Token:        "token",
+		TopicArn:     "arn:aws:sns:us-east-1:444455556666:elsewhere",
+		Message:      "You have chosen to subscribe",
+		SubscribeURL: subscriptions.URL + "/?Action=ConfirmSubscription&Token=token",
+	})
+	if code := post(stranger, sns("SubscriptionConfirmation")); code != http.StatusForbidden || confirmed {
+		t.Errorf("Expected a subscription to another topic to be refused, got %d", code)
+	}
+	if code := post(confirmation, sns("SubscriptionConfirmation")); code != http.StatusNoContent || !confirmed {
+		t.Errorf("Expected the subscription to be confirmed, got %d", code)
+	}
+
+	created := signer.sign(t, snsMessage{Type: "Notification", MessageId: "2", Message: codeArtifactEvent("Created", "Published")})
+	if code := post(created, sns("Notification")); code != http.StatusOK {
+		t.Errorf("Expected the notification to be applied, got %d", code)
+	}
+	stored, err := onlyCopy(storage, id)
+	if err != nil || stored == nil || stored.Status != Published || stored.Revision != "REVISION" || stored.Account != "111122223333" || stored.Region != "us-east-1" {
+		t.Errorf("Expected left-pad 1.3.0 to be published: %+v %v", stored, err)
+	}
+
+	replayed := signer.sign(t, snsMessage{Type: "Notification", MessageId: "5", Timestamp: "2021-11-01T09:00:00.000Z", Message: codeArtifactEvent("Updated", "Archived")})
+	if code := post(replayed, sns("Notification")); code != http.StatusForbidden {
+		t.Errorf("Expected a message sent long ago to be refused, got %d", code)
+	}
+	if stored, _ := onlyCopy(storage, id); stored == nil || stored.Status != Published {
+		t.Errorf("Expected the replayed message not to be applied: %+v", stored)
+	}
+
+	forged := bytes.Replace(signer.sign(t, snsMessage{Type: "Notification", MessageId: "3", Message: codeArtifactEvent("Deleted", "Published")}), []byte(`"MessageId":"3"`), []byte(`"MessageId":"4"`), 1)
+	if code := post(forged, sns("Notification")); code != http.StatusForbidden {
+		t.Errorf("Expected a tampered message to be refused, got %d", code)
+	}
+
+	if code := post([]byte(codeArtifactEvent("Updated", "Unlisted")), nil); code != http.StatusForbidden {
+		t.Errorf("Expected a direct delivery without the key to be refused, got %d", code)
+	}
+	if code := post([]byte(codeArtifactEvent("Updated", "Unlisted")), http.Header{"X-Api-Key": {"secret"}}); code != http.StatusOK {
+		t.Errorf("Expected a direct delivery to be applied, got %d", code)
+	}
+	if stored, _ := onlyCopy(storage, id); stored == nil || stored.Status != Unlisted {
+		t.Errorf("Expected left-pad 1.3.0 to be unlisted: %+v", stored)
+	}
+
+	if code := post([]byte(codeArtifactEvent("Updated", "Archived")), http.Header{"X-Api-Key": {"wrong!"}}); code != http.StatusForbidden {
+		t.Errorf("Expected a direct delivery with the wrong key to be refused, got %d", code)
+	}
+
+	copied := Artifact{ArtifactId: id, DomainName: "acme", Account: "111122223333", Repository: "release", Format: "npm", Status: Published, CreateTime: published}
+	if _, err := storage.Insert(copied); err != nil {
+		t.Fatal(err)
+	}
+	if code := post([]byte(codeArtifactEvent("Deleted", "Unlisted")), http.Header{"X-Api-Key": {"secret"}}); code != http.StatusOK {
+		t.Errorf("Expected a deletion to be applied, got %d", code)
+	}
+	if stored, _ := storage.Get(id, copied.Location()); stored == nil || stored.Status != Published {
+		t.Errorf("Expected the copy in release to be left alone: %+v", stored)
+	}
+	if code := post([]byte(strings.Replace(codeArtifactEvent("Deleted", "Unlisted"), `"internal"`, `"staging"`, 1)), http.Header{"X-Api-Key": {"secret"}}); code != http.StatusNoContent {
+		t.Errorf("Expected the deletion of a version not stored in staging to be ignored, got %d", code)
+	}
+	if copies, _ := storage.Copies(id, Location{}); len(copies) != 2 {
+		t.Errorf("Expected no copy to be made for staging: %+v", copies)
+	}
+	if stored, _ := storage.Get(id, Location{DomainName: "acme", Account: "111122223333", Region: "us-east-1", Repository: "internal"}); stored == nil || stored.Status != Deleted {
+		t.Errorf("Expected left-pad 1.3.0 to be deleted from internal: %+v", stored)
+	}
+}
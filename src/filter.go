@@ -0,0 +1,83 @@
+package artifacts
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PackageFilter narrows the packages imported from the repositories matching Repository, a glob. Empty fields
+// match everything.
+type PackageFilter struct {
+	Repository    string
+	Namespace     string // a namespace prefix
+	PackagePrefix string
+	Formats       []string
+}
+
+// PackageFilters is decoded from JSON, e.g.
+// ARTIFACTS_PACKAGEFILTERS='[{"Repository":"shared-*","Namespace":"com.acme.billing","Formats":["maven"]}]'
+type PackageFilters []PackageFilter
+
+func (f *PackageFilters) Decode(value string) error {
+	return json.Unmarshal([]byte(value), (*[]PackageFilter)(f))
+}
+
+func (f PackageFilter) appliesTo(repository string) bool {
+	return f.Repository == "" || globMatch(f.Repository, repository)
+}
+
+// Matches reports whether a package passes the filter.
+func (f PackageFilter) Matches(format, namespace, pack string) bool {
+	if !strings.HasPrefix(namespace, f.Namespace) || !strings.HasPrefix(pack, f.PackagePrefix) {
+		return false
+	}
+	if len(f.Formats) == 0 {
+		return true
+	}
+	for _, allowed := range f.Formats {
+		if strings.EqualFold(allowed, format) {
+			return true
+		}
+	}
+	return false
+}
+
+// For returns the filters that apply to repository. An empty result means every package is imported.
+func (fs PackageFilters) For(repository string) PackageFilters {
+	applicable := make(PackageFilters, 0)
+	for _, f := range fs {
+		if f.appliesTo(repository) {
+			applicable = append(applicable, f)
+		}
+	}
+	return applicable
+}
+
+// Matches reports whether a package passes any of the filters, or whether there are no filters at all.
+func (fs PackageFilters) Matches(format, namespace, pack string) bool {
+	if len(fs) == 0 {
+		return true
+	}
+	for _, f := range fs {
+		if f.Matches(format, namespace, pack) {
+			return true
+		}
+	}
+	return false
+}
+
+func globMatch(pattern, name string) bool {
+	matched, err := path.Match(pattern, name)
+	return err == nil && matched
+}
+
+func validateGlobs(patterns ...string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// GoProxyImporter reads the configured modules from a GOPROXY using the module proxy protocol. Versions
+// retracted by the latest go.mod are stored as Unlisted.
+type GoProxyImporter struct {
+	registry
+	modules []string
+}
+
+func newGoProxyImporter(c ImporterConfig, s Specification) (Importer, error) {
+	r, err := newRegistry(c, s, "goproxy")
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Packages) == 0 {
+		return nil, fmt.Errorf("goproxy importer needs Packages, the module paths to import")
+	}
+	return &GoProxyImporter{registry: r, modules: c.Packages}, nil
+}
+
+type goVersionInfo struct {
+	Version string
+	Time    time.Time
+}
+
+var majorVersionSuffix = regexp.MustCompile(`^v[0-9]+$`)
+
+// splitModulePath splits a module path into a namespace and package at its last element, keeping a major
+// version suffix with the package: github.com/acme/lib/v2 becomes github.com/acme and lib/v2.
+func splitModulePath(path string) (string, string) {
+	i := strings.LastIndex(path, "/")
+	if i > 0 && majorVersionSuffix.MatchString(path[i+1:]) {
+		if j := strings.LastIndex(path[:i], "/"); j > 0 {
+			i = j
+		}
+	}
+	if i < 0 {
+		return "", path
+	}
+	return path[:i], path[i+1:]
+}
+
+func (g *GoProxyImporter) Import(ctx context.Context, report *SyncReport, out chan<- Artifact) {
+	defer close(out)
+	report.count(1, 0, 0)
+
+	filters := g.PackageFilters.For(g.repository)
+	listed := make([]PackageRef, 0, len(g.modules))
+	complete := true
+	for _, path := range g.modules {
+		if report.IsAborted() || ctx.Err() != nil {
+			return
+		}
+		namespace, pack := splitModulePath(path)
+		if !filters.Matches("go", namespace, pack) {
+			continue
+		}
+		listed = append(listed, PackageRef{Namespace: namespace, Package: pack})
+		report.count(0, 1, 0)
+		err := g.importModule(ctx, path, out)
+		if err != nil {
+			out <- Artifact{ArtifactId: ArtifactId{Namespace: namespace, Package: pack}, DomainName: g.name, Repository: g.repository, Error: err}
+			complete = false
+		}
+	}
+
+	if complete {
+		report.Complete(Scope{DomainName: g.name, Repository: g.repository, Filters: filters, Packages: listed})
+	}
+}
+
+// fetch gets a file from the module's @v directory.
+func (g *GoProxyImporter) fetch(ctx context.Context, escapedPath, file string) ([]byte, error) {
+	u, err := g.resolve(escapedPath + "/@v/" + file)
+	if err != nil {
+		return nil, err
+	}
+	body, _, err := g.get(ctx, u.String(), "")
+	return body, err
+}
+
+func (g *GoProxyImporter) info(ctx context.Context, escapedPath, version string) (goVersionInfo, error) {
+	info := goVersionInfo{}
+	escapedVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return info, err
+	}
+	body, err := g.fetch(ctx, escapedPath, escapedVersion+".info")
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(body, &info)
+	return info, err
+}
+
+func (g *GoProxyImporter) importModule(ctx context.Context, path string, out chan<- Artifact) error {
+	escapedPath, err := module.EscapePath(path)
+	if err != nil {
+		return err
+	}
+	body, err := g.fetch(ctx, escapedPath, "list")
+	if err != nil {
+		return err
+	}
+	versions := strings.Fields(string(body))
+	semver.Sort(versions)
+
+	retractions, err := g.retractions(ctx, escapedPath, latest(versions))
+	if err != nil {
+		return err
+	}
+
+	namespace, pack := splitModulePath(path)
+	for _, version := range versions {
+		info, err := g.info(ctx, escapedPath, version)
+		if err != nil {
+			return err
+		}
+		status := Published
+		if retracted(version, retractions) {
+			status = Unlisted
+		}
+		created := info.Time
+		if created.IsZero() {
+			created = time.Now()
+		}
+		out <- Artifact{
+			ArtifactId: ArtifactId{
+				Namespace: namespace,
+				Package:   pack,
+				Version:   version,
+			},
+			Repository: g.repository,
+			DomainName: g.name,
+			Format:     "go",
+			Status:     status,
+			CreateTime: created,
+		}
+	}
+	return nil
+}
+
+// latest picks the version whose go.mod carries the module's retractions: the highest release, or the highest
+// pre-release if there are no releases. versions must be sorted.
+func latest(versions []string) string {
+	for i := len(versions) - 1; i >= 0; i-- {
+		if semver.Prerelease(versions[i]) == "" {
+			return versions[i]
+		}
+	}
+	if len(versions) > 0 {
+		return versions[len(versions)-1]
+	}
+	return ""
+}
+
+// retractions reads the retract directives from the go.mod of version.
+func (g *GoProxyImporter) retractions(ctx context.Context, escapedPath, version string) ([]*modfile.Retract, error) {
+	if version == "" {
+		return nil, nil
+	}
+	escapedVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	body, err := g.fetch(ctx, escapedPath, escapedVersion+".mod")
+	if err != nil {
+		return nil, err
+	}
+	file, err := modfile.ParseLax("go.mod", body, nil)
+	if err != nil {
+		return nil, err
+	}
+	return file.Retract, nil
+}
+
+func retracted(version string, retractions []*modfile.Retract) bool {
+	for _, r := range retractions {
+		if semver.Compare(r.Low, version) <= 0 && semver.Compare(version, r.High) <= 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package artifacts
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGoProxyImporter(t *testing.T) {
+	files := map[string]string{
+		"/github.com/!acme/lib/v2/@v/list":             "v2.0.0\nv2.1.0\nv2.0.1\nv2.2.0-rc.1\n",
+		"/github.com/!acme/lib/v2/@v/v2.0.0.info":      `{"Version": "v2.0.0", "Time": "2021-01-01T00:00:00Z"}`,
+		"/github.com/!acme/lib/v2/@v/v2.0.1.info":      `{"Version": "v2.0.1", "Time": "2021-02-01T00:00:00Z"}`,
+		"/github.com/!acme/lib/v2/@v/v2.1.0.info":      `{"Version": "v2.1.0", "Time": "2021-03-01T00:00:00Z"}`,
+		"/github.com/!acme/lib/v2/@v/v2.2.0-rc.1.info": `{"Version": "v2.2.0-rc.1", "Time": "2021-04-01T00:00:00Z"}`,
+		"/github.com/!acme/lib/v2/@v/v2.1.0.mod": `module github.com/Acme/lib/v2
+
+go 1.17
+
+retract (
+	v2.0.0 // published by accident
+	[v2.0.1, v2.0.9]
+)
+`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	storage := newTestStorage(t)
+	s := Specification{
+		PageSize:  10,
+		Importers: ImporterConfigs{{Type: "goproxy", Url: server.URL, Packages: []string{"github.com/Acme/lib/v2"}}},
+	}
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := LoadArtifacts(context.Background(), importers, s, storage)
+	if len(report.Failures) > 0 {
+		t.Fatalf("Unexpected failures %+v", report.Failures)
+	}
+
+	list, err := storage.List(AllStatuses, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	statuses := make(map[string]Status)
+	for _, a := range list {
+		if a.Namespace != "github.com/Acme" || a.Package != "lib/v2" || a.Format != "go" {
+			t.Errorf("Unexpected artifact %+v", a)
+		}
+		if a.Version == "v2.1.0" && a.CreateTime.Month() != 3 {
+			t.Errorf("Expected the publish time from the .info file, got %v", a.CreateTime)
+		}
+		statuses[a.Version] = a.Status
+	}
+	expected := map[string]Status{"v2.0.0": Unlisted, "v2.0.1": Unlisted, "v2.1.0": Published, "v2.2.0-rc.1": Published}
+	for v, status := range expected {
+		if statuses[v] != status {
+			t.Errorf("Expected %s to be %s, got %+v", v, status, statuses)
+		}
+	}
+}
@@ -2,16 +2,23 @@ package artifacts
 
 import (
 	json "encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gorilla/mux"
 	"github.com/rs/zerolog/log"
 	"html/template"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 )
 
-func initRouting(storage Storage) *mux.Router {
+// Routes adds handlers beyond the catalog's own to the router, for features that need more than Storage.
+type Routes func(r *mux.Router)
+
+func initRouting(storage Storage, routes ...Routes) *mux.Router {
 
 	r := mux.NewRouter()
 
@@ -55,8 +62,8 @@ func initRouting(storage Storage) *mux.Router {
 			log.Error().Err(err).Msgf("Insert failed")
 			return
 		}
-		for _, artifact := range insert {
-			artifact.populateProblems()
+		for i := range insert {
+			insert[i].populateProblems()
 		}
 		marshal, err := json.Marshal(insert)
 		if err != nil {
@@ -83,19 +90,24 @@ func initRouting(storage Storage) *mux.Router {
 			Statuses:  AllStatuses,
 			Namespace: ns,
 			Package:   ps,
+			Updatable: UpdatableStatuses,
 		})
 
 	})
 
+	for _, add := range routes {
+		add(r)
+	}
+
 	// Add handler for static files
 	r.PathPrefix("/").Handler(http.FileServer(http.Dir("static")))
 
 	return r
 }
 
-func NewServer(addr string, storage Storage) *http.Server {
+func NewServer(addr string, storage Storage, routes ...Routes) *http.Server {
 	// Setup router
-	router := initRouting(storage)
+	router := initRouting(storage, routes...)
 
 	// Create and start server
 	return &http.Server{
@@ -104,6 +116,138 @@ func NewServer(addr string, storage Storage) *http.Server {
 	}
 }
 
+// DryRunRoutes serves POST /dry-run, which starts running the importers against the catalog without changing it
+// on behalf of the user named by the s.UserHeader header, and GET /dry-run/{id}, which shows how far it got. Only
+// one dry run goes at a time; starting another while one is running answers with that one. A dry run is JSON
+// when requested with Content-Type: application/json, and a plain text summary otherwise.
+func DryRunRoutes(importers []Importer, s Specification, session *BoltStorage) Routes {
+	jobs := newDryRunJobs(importers, s, session)
+	respond := func(writer http.ResponseWriter, request *http.Request, job DryRunJob, err error) {
+		switch {
+		case err == errNoUser:
+			http.Error(writer, err.Error(), http.StatusUnauthorized)
+			return
+		case err == ErrDryRunNotFound:
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if request.Header.Get("Content-Type") == "application/json" {
+			jsonObjects, err := json.Marshal(job)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			if request.Method == "POST" {
+				writer.Header().Set("Location", "/dry-run/"+job.Id)
+				writer.WriteHeader(http.StatusAccepted)
+			}
+			_, _ = writer.Write(jsonObjects)
+			return
+		}
+		if request.Method == "POST" {
+			http.Redirect(writer, request, "/dry-run/"+job.Id, http.StatusSeeOther)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if job.Diff == nil {
+			_, _ = fmt.Fprintf(writer, "running since %s, started by %s\n", job.Started.Format(time.RFC3339), job.User)
+			return
+		}
+		_, _ = io.WriteString(writer, job.Diff.Summary())
+	}
+
+	return func(r *mux.Router) {
+		r.Methods("POST").Path("/dry-run").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			job, err := jobs.start(request.Header.Get(s.UserHeader))
+			respond(writer, request, job, err)
+		})
+
+		r.Methods("GET").Path("/dry-run/{id}").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			job, err := jobs.get(mux.Vars(request)["id"])
+			respond(writer, request, job, err)
+		})
+	}
+}
+
+// StatusRequest asks for the named artifacts to be moved to Status.
+type StatusRequest struct {
+	Status    Status
+	Artifacts []string
+}
+
+// sameSite reports whether a form was posted from the catalog's own pages, going by the Origin header browsers
+// send with posts, or the Referer when there is no Origin. A form without either isn't trusted.
+func sameSite(request *http.Request) bool {
+	source := request.Header.Get("Origin")
+	if source == "" {
+		source = request.Header.Get("Referer")
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Host == request.Host || u.Host == request.Header.Get("X-Forwarded-Host")
+}
+
+// userStatus is the HTTP status for errors changing the catalog on a user's behalf.
+func userStatus(err error) int {
+	if errors.Is(err, errNoUser) {
+		return http.StatusUnauthorized
+	}
+	return http.StatusBadRequest
+}
+
+// StatusRoutes serves POST /artifacts/status, which changes the status of artifacts in CodeArtifact and then the
+// catalog on behalf of the user named by the s.UserHeader header. It takes a JSON StatusRequest, answering with
+// the outcome per artifact, or the listing's form, which names artifacts as namespace:package:version and must be
+// posted from the catalog's own pages.
+func StatusRoutes(s Specification, domains Domains, session *BoltStorage) Routes {
+	return func(r *mux.Router) {
+		r.Methods("POST").Headers("Content-Type", "application/json").Path("/artifacts/status").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			body := StatusRequest{}
+			if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			outcomes, err := UpdateStatus(request.Context(), domains, session, request.Header.Get(s.UserHeader), body.Status, body.Artifacts)
+			if err != nil {
+				http.Error(writer, err.Error(), userStatus(err))
+				return
+			}
+			jsonObjects, err := json.Marshal(outcomes)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			_, _ = writer.Write(jsonObjects)
+		})
+
+		r.Methods("POST").Path("/artifacts/status").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if !sameSite(request) {
+				http.Error(writer, "Forms must be posted from the catalog", http.StatusForbidden)
+				return
+			}
+			if err := request.ParseForm(); err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			user := request.Header.Get(s.UserHeader)
+			status := Status(request.PostForm.Get("status"))
+			outcomes, err := UpdateStatus(request.Context(), domains, session, user, status, request.PostForm["artifact"])
+			if err != nil {
+				http.Error(writer, err.Error(), userStatus(err))
+				return
+			}
+			renderTemplate(writer, "status", StatusHtmlContext{Status: status, User: user, Outcomes: outcomes})
+		})
+	}
+}
+
 func StartServer(server *http.Server) {
 	log.Info().Msgf("Starting server %s", server.Addr)
 	err := server.ListenAndServe()
@@ -117,6 +261,14 @@ type ListHtmlContext struct {
 	Statuses  []Status
 	Namespace string
 	Package   string
+	// Updatable are the statuses selected artifacts can be moved to
+	Updatable []Status
+}
+
+type StatusHtmlContext struct {
+	Status   Status
+	User     string
+	Outcomes []StatusOutcome
 }
 
 func fetchArtifactsForQuery(request *http.Request, storage Storage) ([]Artifact, string, string, error) {
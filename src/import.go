@@ -1,72 +1,382 @@
 package artifacts
 
 import (
-	"github.com/aws/aws-sdk-go/service/codeartifact"
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact/types"
 	"github.com/rs/zerolog/log"
+	"sort"
+	"sync"
 	"time"
 )
 
-func LoadArtifacts(err error, aux CodeArtifactWrapper, s Specification, session *BoltStorage) {
-	repos, err := aux.AllRepos()
-	if err != nil {
-		log.Fatal().Msgf("Failed to list repos %v\n", err)
+// SyncReport summarises a single run of LoadArtifacts. Failures are collected per package so one bad package
+// doesn't take the whole import (or the web server) down with it.
+type SyncReport struct {
+	Started      time.Time
+	Finished     time.Time
+	Repositories int
+	Packages     int
+	Inserted     int
+	Failures     []PackageFailure
+	Reconciled   []Reconciliation
+	Aborted      bool
+	AbortReason  string `json:",omitempty"`
+
+	threshold int
+	completed []Scope
+	// checkpoints is where importers save their progress, and settings where they record how repositories are
+	// connected and packages restricted, if anywhere
+	checkpoints *BoltStorage
+	settings    *BoltStorage
+	mu          sync.Mutex
+}
+
+// PackageFailure collects everything that went wrong while importing one package. Failures listing a whole
+// repository have an empty Namespace and Package.
+type PackageFailure struct {
+	DomainName string `json:",omitempty"`
+	Repository string
+	Namespace  string `json:",omitempty"`
+	Package    string `json:",omitempty"`
+	Errors     []string
+}
+
+// Reconciliation records the stored artifacts of a fully crawled repository that CodeArtifact no longer lists.
+type Reconciliation struct {
+	DomainName string
+	Repository string
+	Status     Status
+	Artifacts  []ArtifactId
+}
+
+func NewSyncReport(failureThreshold int) *SyncReport {
+	return &SyncReport{
+		Started:    time.Now(),
+		Failures:   make([]PackageFailure, 0),
+		Reconciled: make([]Reconciliation, 0),
+		threshold:  failureThreshold,
 	}
+}
 
-	for _, repo := range repos.Repositories {
-		if s.Skip(*repo.Name) {
-			log.Printf("Skipping %v\n", *repo.Name)
-			continue
+// Fail records err against the package. Once the number of failed packages reaches the failure threshold the
+// run is aborted.
+func (r *SyncReport) Fail(domain, repository, namespace, pack string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log.Warn().Err(err).Str("domain", domain).Str("repository", repository).Str("namespace", namespace).Str("package", pack).Msg("Import failure")
+
+	found := false
+	for i, f := range r.Failures {
+		if f.DomainName == domain && f.Repository == repository && f.Namespace == namespace && f.Package == pack {
+			r.Failures[i].Errors = append(f.Errors, err.Error())
+			found = true
+			break
 		}
-		log.Printf("Extracting REpo %v", repo)
+	}
+	if !found {
+		r.Failures = append(r.Failures, PackageFailure{
+			DomainName: domain,
+			Repository: repository,
+			Namespace:  namespace,
+			Package:    pack,
+			Errors:     []string{err.Error()},
+		})
+	}
 
-		// a channel of packages for this repo
-		ps := make(chan Package)
-		go Packages(repo, aux, ps)
+	if r.threshold > 0 && len(r.Failures) >= r.threshold && !r.Aborted {
+		r.Aborted = true
+		r.AbortReason = fmt.Sprintf("%d packages failed, threshold is %d", len(r.Failures), r.threshold)
+	}
+}
 
-		for p := range ps {
-			if p.Error != nil {
-				log.Fatal().Stack().Err(p.Error)
-			}
-			log.Debug().
-				Interface("package", p).
-				Msg("Extracting package")
+// failed reports whether anything in repository failed during this run.
+func (r *SyncReport) failed(domain, repository string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range r.Failures {
+		if f.DomainName == domain && f.Repository == repository {
+			return true
+		}
+	}
+	return false
+}
 
-			// a channel of artifacts
-			as := make(chan Artifact, s.PageSize)
+// Complete marks a repository as listed in full, so once the import finishes stored artifacts the listing
+// didn't include can be reconciled.
+func (r *SyncReport) Complete(scope Scope) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.completed = append(r.completed, scope)
+}
+
+func (r *SyncReport) takeCompleted() []Scope {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	completed := r.completed
+	r.completed = nil
+	return completed
+}
+
+func (r *SyncReport) reconciled(rec Reconciliation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Reconciled = append(r.Reconciled, rec)
+}
 
-			go Versions(p, aux, as)
+func (r *SyncReport) IsAborted() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.Aborted
+}
+
+func (r *SyncReport) abort(reason string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Aborted = true
+	r.AbortReason = reason
+}
+
+func (r *SyncReport) count(repositories, packages, inserted int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Repositories += repositories
+	r.Packages += packages
+	r.Inserted += inserted
+}
+
+func (r *SyncReport) finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Finished = time.Now()
+}
 
-			//for artifact := range as {
-			//	insertBatch([]Artifact{artifact}, p, session)
-			//}
-			batchArtifacts := BatchArtifacts(s.PageSize, as)
+// RetriesExhausted is returned by retry when every attempt failed. It wraps the last error seen.
+type RetriesExhausted struct {
+	Attempts int
+	Err      error
+}
+
+func (r *RetriesExhausted) Error() string {
+	return fmt.Sprintf("giving up after %d attempts: %v", r.Attempts, r.Err)
+}
+
+func (r *RetriesExhausted) Unwrap() error {
+	return r.Err
+}
+
+// retry calls f until it succeeds, up to attempts times, doubling the wait between attempts starting at backoff.
+// It gives up early, returning the last error, once ctx is done.
+func retry(ctx context.Context, attempts int, backoff time.Duration, f func() error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for i := 1; ; i++ {
+		err = f()
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		if i >= attempts {
+			return &RetriesExhausted{Attempts: i, Err: err}
+		}
+		log.Debug().Err(err).Int("attempt", i).Dur("backoff", backoff).Msg("Retrying")
+		wait := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			wait.Stop()
+			return err
+		case <-wait.C:
+		}
+		backoff *= 2
+	}
+}
 
-			for batch := range batchArtifacts {
-				insertBatch(batch, p, session)
+// LoadArtifacts runs each importer in turn and inserts what it finds into one catalog. It never exits the
+// process: failures are retried, then recorded on the returned report, and the run is abandoned once
+// Specification.FailureThreshold packages have failed or ctx is done.
+func LoadArtifacts(ctx context.Context, importers []Importer, s Specification, session *BoltStorage) *SyncReport {
+	report := NewSyncReport(s.FailureThreshold)
+	report.checkpoints = session
+	report.settings = session
+	return runImporters(ctx, importers, s, catalogSink{session}, report)
+}
+
+// importSink receives what the importers find. LoadArtifacts writes it to the catalog, while DryRun only records
+// how the catalog would change.
+type importSink interface {
+	insert(batch []Artifact, report *SyncReport)
+	reconcile(status Status, scope Scope, seen map[ArtifactId]bool, report *SyncReport)
+}
+
+type catalogSink struct {
+	session *BoltStorage
+}
+
+func (c catalogSink) insert(batch []Artifact, report *SyncReport) {
+	insertBatch(batch, c.session, report)
+}
+
+func (c catalogSink) reconcile(status Status, scope Scope, seen map[ArtifactId]bool, report *SyncReport) {
+	reconcile(c.session, status, scope, seen, report)
+}
+
+func runImporters(ctx context.Context, importers []Importer, s Specification, sink importSink, report *SyncReport) *SyncReport {
+	defer report.finish()
+
+	for _, importer := range importers {
+		if report.IsAborted() {
+			break
+		}
+		runImporter(ctx, importer, s, sink, report)
+	}
+
+	return report
+}
+
+// runImporter sends the artifacts importer streams to sink in batches, then reconciles the repositories it listed
+// completely. An import cut short by ctx aborts the run, so nothing is reconciled against a partial listing.
+func runImporter(ctx context.Context, importer Importer, s Specification, sink importSink, report *SyncReport) {
+	log.Info().Str("importer", importer.Name()).Msg("Starting import")
+	start := time.Now()
+
+	// a channel of artifacts
+	as := make(chan Artifact, s.PageSize)
+	go importer.Import(ctx, report, as)
+
+	// every version the source still lists, per repository
+	seen := make(map[repositoryKey]map[ArtifactId]bool)
+
+	for batch := range BatchArtifacts(s.PageSize, as) {
+		for _, a := range batch {
+			if a.Error != nil {
+				continue
+			}
+			k := repositoryKey{a.DomainName, a.Account, a.Region, a.Repository}
+			if seen[k] == nil {
+				seen[k] = make(map[ArtifactId]bool)
 			}
+			seen[k][a.ArtifactId] = true
+		}
+		sink.insert(batch, report)
+	}
+	if err := ctx.Err(); err != nil && !report.IsAborted() {
+		report.abort(fmt.Sprintf("import stopped: %v", err))
+	}
 
+	// only a complete listing can tell us what's gone
+	for _, scope := range report.takeCompleted() {
+		if !s.Reconcile || report.IsAborted() || report.failed(scope.DomainName, scope.Repository) {
+			continue
 		}
+		sink.reconcile(s.ReconcileStatus, scope, seen[scope.key()], report)
 	}
+	log.Info().Str("importer", importer.Name()).Dur("duration", time.Since(start)).Msg("Finished import")
 }
 
-func insertBatch(batch []Artifact, p Package, session *BoltStorage) {
-	for _, a := range batch {
-		if a.Error != nil {
-			log.Fatal().Err(a.Error).Msgf("Failed retrieving artifact from aws for package %v", p)
+// reconcile moves the artifacts stored for the scope's repository that weren't seen in the latest crawl to
+// status. Only artifacts the scope covers are considered, since nothing else was crawled.
+func reconcile(session *BoltStorage, status Status, scope Scope, seen map[ArtifactId]bool, report *SyncReport) {
+	domain, repository := scope.DomainName, scope.Repository
+	missing, err := missingArtifacts(session, status, scope, seen)
+	if err != nil {
+		report.Fail(domain, repository, "", "", fmt.Errorf("reconciling: %w", err))
+		return
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	moved, err := session.SetStatus(status, missing...)
+	if err != nil {
+		report.Fail(domain, repository, "", "", fmt.Errorf("reconciling: %w", err))
+		return
+	}
+
+	ids := artifactIds(moved)
+	log.Info().Str("repository", repository).Int("artifacts", len(ids)).Str("status", string(status)).Msg("Reconciled missing artifacts")
+	report.reconciled(Reconciliation{
+		DomainName: domain,
+		Repository: repository,
+		Status:     status,
+		Artifacts:  ids,
+	})
+}
+
+func artifactIds(artifacts []Artifact) []ArtifactId {
+	ids := make([]ArtifactId, 0, len(artifacts))
+	for _, a := range artifacts {
+		ids = append(ids, a.ArtifactId)
+	}
+	return ids
+}
+
+// missingArtifacts lists the artifacts stored for the scope's repository that weren't seen and aren't already
+// in status, or deleted.
+func missingArtifacts(session *BoltStorage, status Status, scope Scope, seen map[ArtifactId]bool) ([]Artifact, error) {
+	stored, err := session.ForRepository(scope.location())
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make([]Artifact, 0)
+	for _, a := range stored {
+		if seen[a.ArtifactId] || a.Status == status || a.Status == Deleted {
+			continue
+		}
+		if !scope.covers(a) {
+			continue
 		}
+		missing = append(missing, a)
 	}
+	return missing, nil
+}
 
-	arts, err := session.Insert(batch...)
+func insertBatch(batch []Artifact, session *BoltStorage, report *SyncReport) {
+	handled := func(a Artifact, stored bool) {
+		if a.done != nil {
+			a.done(stored)
+		}
+	}
 
-	for _, a := range arts {
+	valid := make([]Artifact, 0, len(batch))
+	for _, a := range batch {
 		if a.Error != nil {
-			log.Err(a.Error)
+			report.Fail(a.DomainName, a.Repository, a.Namespace, a.Package, a.Error)
+			handled(a, false)
+			continue
 		}
+		valid = append(valid, a)
+	}
+	if len(valid) == 0 {
+		return
 	}
 
+	arts, err := session.Insert(valid...)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error on insert; exiting")
+		for _, a := range valid {
+			report.Fail(a.DomainName, a.Repository, a.Namespace, a.Package, err)
+			handled(a, false)
+		}
+		return
+	}
+
+	inserted := 0
+	for _, a := range arts {
+		if a.Error != nil {
+			report.Fail(a.DomainName, a.Repository, a.Namespace, a.Package, fmt.Errorf("version %s: %w", a.Version, a.Error))
+		} else {
+			inserted++
+		}
+		handled(a, a.Error == nil)
 	}
+	report.count(0, 0, inserted)
 }
 
 func BatchArtifacts(batchSize int, inChan chan Artifact) chan []Artifact {
@@ -78,6 +388,9 @@ func BatchArtifacts(batchSize int, inChan chan Artifact) chan []Artifact {
 			select {
 			case event, ok := <-inChan:
 				if !ok {
+					if len(batch) > 0 {
+						outChan <- batch
+					}
 					return
 				}
 
@@ -94,6 +407,7 @@ func BatchArtifacts(batchSize int, inChan chan Artifact) chan []Artifact {
 				// process whatever we have seen so far if the batch size isn't filled in 5 secs
 				if len(batch) > 0 {
 					outChan <- batch
+					batch = make([]Artifact, 0)
 				}
 			}
 		}
@@ -101,57 +415,347 @@ func BatchArtifacts(batchSize int, inChan chan Artifact) chan []Artifact {
 	return outChan
 }
 
-func Packages(repository *codeartifact.RepositorySummary, aux CodeArtifactWrapper, ps chan Package) {
-	packages, err := aux.AllPackagesInRepo(repository)
+func (s *CodeArtifactWrapper) Name() string {
+	return "codeartifact:" + s.Target.Domain
+}
+
+// checkpointKey is what the import's checkpoint is saved under. Domain names are only unique within an account,
+// so the key includes the owner when it's configured.
+func (s *CodeArtifactWrapper) checkpointKey() string {
+	if s.Target.DomainOwner == "" {
+		return s.Name()
+	}
+	return "codeartifact:" + s.Target.DomainOwner + ":" + s.Target.Domain
+}
+
+// Import crawls every repository in the target domain, streaming each package's versions to out. Progress is
+// checkpointed as packages are stored, and an import that was interrupted resumes from its checkpoint unless
+// Fresh is set.
+func (s *CodeArtifactWrapper) Import(ctx context.Context, report *SyncReport, out chan<- Artifact) {
+	defer close(out)
+	domain := s.Target.Domain
+	log.Info().Interface("target", s.Target).Msg("Importing domain")
+
+	var repos codeartifact.ListRepositoriesInDomainOutput
+	err := retry(ctx, s.Retries, s.RetryBackoff, func() (err error) {
+		repos, err = s.AllRepos(ctx)
+		return err
+	})
 	if err != nil {
-		ps <- Package{Error: err}
-		close(ps)
+		out <- Artifact{DomainName: domain, Error: err}
+		return
+	}
+	if report.settings != nil {
+		listed := make([]string, 0, len(repos.Repositories))
+		for _, repo := range repos.Repositories {
+			listed = append(listed, aws.ToString(repo.Name))
+		}
+		if err := report.settings.PruneRepositories(domain, listed); err != nil {
+			log.Error().Err(err).Str("domain", domain).Msg("Failed to forget removed repositories")
+		}
+	}
+	// checkpoints rely on a stable order
+	sort.Slice(repos.Repositories, func(i, j int) bool {
+		return aws.ToString(repos.Repositories[i].Name) < aws.ToString(repos.Repositories[j].Name)
+	})
+
+	checkpoints := newCheckpointer(report.checkpoints, s.checkpointKey())
+	from, err := checkpoints.load(s.Fresh)
+	if err != nil {
+		log.Warn().Err(err).Str("importer", s.Name()).Msg("Failed to load import checkpoint; starting from the beginning")
+		from = nil
+	}
+	if from != nil {
+		log.Info().Interface("checkpoint", from).Str("importer", s.Name()).Msg("Resuming import")
+	}
+
+	for i := range repos.Repositories {
+		repo := &repos.Repositories[i]
+		if report.IsAborted() || ctx.Err() != nil {
+			return
+		}
+		name := *repo.Name
+		position := Checkpoint{Repository: i, RepositoryName: name}
+		resumed := false
+		if from != nil {
+			if name < from.RepositoryName {
+				continue
+			}
+			if name == from.RepositoryName {
+				position = *from
+				position.Repository = i
+				resumed = true
+			}
+			from = nil
+		}
+		if s.Skip(name) {
+			log.Printf("Skipping %v\n", name)
+			continue
+		}
+		log.Printf("Extracting REpo %v", repo)
+		report.count(1, 0, 0)
+		s.describeRepository(ctx, report, name)
+		// a resumed repository wasn't listed in full by this run, so it can't be reconciled
+		complete := !resumed
+
+		// a channel of packages for this repo
+		ps := make(chan Package)
+		go Packages(ctx, repo, *s, position, checkpoints, ps)
+
+		origins := make([]PackageOrigin, 0)
+		for p := range ps {
+			if p.Error != nil {
+				out <- Artifact{DomainName: domain, Repository: name, Error: p.Error}
+				complete = false
+				continue
+			}
+			if report.IsAborted() {
+				// keep draining so the Packages goroutine can finish
+				complete = false
+				continue
+			}
+			log.Debug().
+				Interface("package", p).
+				Msg("Extracting package")
+			report.count(0, 1, 0)
+			if origin, ok := packageOrigin(domain, name, p.PackageSummary); ok {
+				origins = append(origins, origin)
+			}
+
+			// a channel of artifacts
+			as := make(chan Artifact, s.PageSize)
+
+			go Versions(ctx, p, *s, as)
+
+			forward(as, out, p.done)
+		}
+		recordOrigins(report, origins)
+
+		if complete {
+			report.Complete(Scope{DomainName: domain, Account: aws.ToString(repo.DomainOwner), Region: s.Target.Region, Repository: name, Filters: s.PackageFilters.For(name)})
+		}
+	}
+
+	if !report.IsAborted() && ctx.Err() == nil {
+		checkpoints.finish()
+	}
+}
+
+// forward sends a package's artifacts on to out, arranging for done to be called once the last of them has been
+// handled, with whether every one of them was stored. A package without versions is done straight away. Artifacts
+// are handled in the order they're sent, by one goroutine.
+func forward(as chan Artifact, out chan<- Artifact, done func(stored bool)) {
+	failed := false
+	handled := func(stored bool) {
+		failed = failed || !stored
+	}
+	var last *Artifact
+	for a := range as {
+		if last != nil {
+			out <- *last
+		}
+		a := a
+		a.done = handled
+		last = &a
+	}
+	if last == nil {
+		if done != nil {
+			done(true)
+		}
 		return
 	}
+	last.done = func(stored bool) {
+		if done != nil {
+			done(stored && !failed)
+		}
+	}
+	out <- *last
+}
 
-	log.Info().Msgf("Found %d packages in %s", len(packages.Packages), *repository.Name)
+// Packages lists repository a page at a time, starting from position and skipping the packages it records as
+// completed. Each page is registered with checkpoints before its packages are sent.
+func Packages(ctx context.Context, repository *types.RepositorySummary, aux CodeArtifactWrapper, position Checkpoint, checkpoints *checkpointer, ps chan Package) {
+	defer close(ps)
+	name := *repository.Name
+	filters := aux.PackageFilters.For(name)
+	listings := packageListings(filters)
 	start := time.Now()
 
-	for _, pack := range packages.Packages {
-		ps <- Package{
-			RepositorySummary: repository,
-			PackageSummary:    pack,
+	// a package can match more than one filter, but is only imported once
+	found := make(map[PackageRef]bool)
+	first := true
+	for l := position.Listing; l < len(listings); l++ {
+		token := ""
+		if first {
+			token = position.NextToken
 		}
+		for {
+			var page codeartifact.ListPackagesOutput
+			err := retry(ctx, aux.Retries, aux.RetryBackoff, func() (err error) {
+				page, err = aux.PackagesPage(ctx, repository, listings[l], token)
+				return err
+			})
+			if err != nil {
+				ps <- Package{Error: err}
+				return
+			}
+
+			at := Checkpoint{Repository: position.Repository, RepositoryName: name, Listing: l, NextToken: token}
+			if first {
+				at.Completed = position.Completed
+				first = false
+			}
+			next := Checkpoint{Repository: position.Repository, RepositoryName: name, Listing: l, NextToken: aws.ToString(page.NextToken)}
+			if page.NextToken == nil {
+				next.Listing, next.NextToken = l+1, ""
+			}
+
+			packages := make([]*types.PackageSummary, 0, len(page.Packages))
+			for i := range page.Packages {
+				pack := &page.Packages[i]
+				format, ref := string(pack.Format), PackageRef{Namespace: aws.ToString(pack.Namespace), Package: aws.ToString(pack.Package)}
+				if found[ref] || at.completed(ref) || !filters.Matches(format, ref.Namespace, ref.Package) {
+					continue
+				}
+				found[ref] = true
+				packages = append(packages, pack)
+			}
+			log.Info().Msgf("Found %d packages in a page of %s", len(packages), name)
+
+			done := checkpoints.page(at, next, len(packages))
+			for _, pack := range packages {
+				ps <- Package{
+					RepositorySummary: repository,
+					PackageSummary:    pack,
+					done:              done(PackageRef{Namespace: aws.ToString(pack.Namespace), Package: aws.ToString(pack.Package)}),
+				}
+			}
+
+			if page.NextToken == nil {
+				break
+			}
+			token = *page.NextToken
+		}
+		first = false
+	}
+	log.Info().Msgf("Finished repository %s in %s", name, time.Since(start))
+}
+
+// packageListings returns the filters to list packages with, one listing per filter, or a single unfiltered
+// listing when there are none.
+func packageListings(filters PackageFilters) []*PackageFilter {
+	listings := make([]*PackageFilter, 0, len(filters))
+	for i := range filters {
+		listings = append(listings, &filters[i])
+	}
+	if len(listings) == 0 {
+		listings = append(listings, nil)
 	}
-	log.Info().Msgf("Finished repository %s in %s", *repository.Name, time.Since(start))
-	close(ps)
+	return listings
 }
 
-func Versions(p Package, aux CodeArtifactWrapper, vers chan Artifact) {
-	response, err := aux.AllPackageVersions(p.PackageSummary, p.RepositorySummary)
+func Versions(ctx context.Context, p Package, aux CodeArtifactWrapper, vers chan Artifact) {
+	var response codeartifact.ListPackageVersionsOutput
+	err := retry(ctx, aux.Retries, aux.RetryBackoff, func() (err error) {
+		response, err = aux.AllPackageVersions(ctx, p.PackageSummary, p.RepositorySummary)
+		return err
+	})
 	if err != nil {
-		vers <- Artifact{Error: err}
+		vers <- Artifact{
+			ArtifactId: ArtifactId{Namespace: aws.ToString(p.Namespace), Package: aws.ToString(p.Package)},
+			Repository: aws.ToString(p.Name),
+			DomainName: aws.ToString(p.DomainName),
+			Error:      err,
+		}
 		log.Info().Err(err).Interface("package", p.PackageSummary).Msg("Error extracting versions for package")
 		close(vers)
 		return
 	}
 	log.Info().Int("versions", len(response.Versions)).Interface("package", response.Package).Msg("Retrieving package")
 
-	for _, version := range response.Versions {
-		packageId := *p.Package
-		namespace := *p.Namespace
-		v := *version.Version
-		status := Status(*version.Status)
-
-		artifact := Artifact{
-			Repository: *p.Name,
-			ArtifactId: ArtifactId{
-				Package:   packageId,
-				Namespace: namespace,
-				Version:   v,
-			},
-			Revision:   *version.Revision,
-			DomainName: *p.DomainName,
-			Format:     *p.Format,
-			Status:     status,
-			CreateTime: time.Now(),
-		}
-		vers <- artifact
+	artifacts := make(chan Artifact)
+	go func() {
+		defer close(artifacts)
+		for _, version := range response.Versions {
+			artifacts <- Artifact{
+				Repository: aws.ToString(p.Name),
+				ArtifactId: ArtifactId{
+					Package:   aws.ToString(p.Package),
+					Namespace: aws.ToString(p.Namespace),
+					Version:   aws.ToString(version.Version),
+				},
+				Revision:   aws.ToString(version.Revision),
+				DomainName: aws.ToString(p.DomainName),
+				Account:    aws.ToString(p.DomainOwner),
+				Region:     aux.Target.Region,
+				Format:     string(p.Format),
+				Status:     Status(version.Status),
+				CreateTime: time.Now(),
+
+				ExternalConnection: externalConnection(version.Origin),
+			}
+		}
+	}()
+
+	if !aux.Describe {
+		for artifact := range artifacts {
+			vers <- artifact
+		}
+		close(vers)
+		return
+	}
+
+	workers := aux.DescribeConcurrency
+	if workers < 1 {
+		workers = 1
 	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for artifact := range artifacts {
+				vers <- describe(ctx, artifact, p, aux)
+			}
+		}()
+	}
+	wg.Wait()
 	close(vers)
 }
+
+// externalConnection names the external connection a version was cached from, if it was.
+func externalConnection(origin *types.PackageVersionOrigin) string {
+	if origin == nil || origin.OriginType != types.PackageVersionOriginTypeExternal || origin.DomainEntryPoint == nil {
+		return ""
+	}
+	return aws.ToString(origin.DomainEntryPoint.ExternalConnectionName)
+}
+
+// describe fills in the publish time and metadata CodeArtifact only returns per version. If the description
+// can't be fetched the artifact is returned as listed, stamped with the import time.
+func describe(ctx context.Context, artifact Artifact, p Package, aux CodeArtifactWrapper) Artifact {
+	var description *types.PackageVersionDescription
+	err := retry(ctx, aux.Retries, aux.RetryBackoff, func() (err error) {
+		description, err = aux.DescribeVersion(ctx, p, artifact.Version)
+		return err
+	})
+	if err != nil {
+		log.Warn().Err(err).Interface("artifact", artifact.ArtifactId).Msg("Failed to describe version; keeping import time")
+		return artifact
+	}
+
+	if description.PublishedTime != nil {
+		artifact.CreateTime = *description.PublishedTime
+	}
+	if description.Revision != nil {
+		artifact.Revision = *description.Revision
+	}
+	artifact.DisplayName = aws.ToString(description.DisplayName)
+	artifact.Summary = aws.ToString(description.Summary)
+	artifact.HomePage = aws.ToString(description.HomePage)
+	artifact.SourceCodeRepository = aws.ToString(description.SourceCodeRepository)
+	for _, license := range description.Licenses {
+		artifact.Licenses = append(artifact.Licenses, aws.ToString(license.Name))
+	}
+	return artifact
+}
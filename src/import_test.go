@@ -1,7 +1,11 @@
 package artifacts
 
 import (
+	"context"
+	"errors"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestBatchArtifacts(t *testing.T) {
@@ -61,3 +65,231 @@ func TestBatchArtifacts(t *testing.T) {
 		})
 	}
 }
+
+func TestBatchArtifactsFlushesOnClose(t *testing.T) {
+	in := make(chan Artifact)
+	batches := BatchArtifacts(10, in)
+	go func() {
+		in <- Artifact{Repository: "1"}
+		in <- Artifact{Repository: "2"}
+		close(in)
+	}()
+
+	total := 0
+	for batch := range batches {
+		total += len(batch)
+	}
+	if total != 2 {
+		t.Fatalf("Expected the partial batch to be emitted on close, got %d artifacts", total)
+	}
+}
+
+func TestNewImportersWithoutDomain(t *testing.T) {
+	importers, err := NewImporters(Specification{Region: "us-east-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(importers) != 0 {
+		t.Errorf("Expected no default importer without a Domain, got %+v", importers)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	err := retry(context.Background(), 3, time.Millisecond, func() error {
+		calls++
+		if calls < 2 {
+			return errors.New("flaky")
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Fatalf("Expected success on the second call, got %v after %d calls", err, calls)
+	}
+
+	calls = 0
+	err = retry(context.Background(), 3, time.Millisecond, func() error {
+		calls++
+		return errors.New("broken")
+	})
+	var exhausted *RetriesExhausted
+	if !errors.As(err, &exhausted) || exhausted.Attempts != 3 || calls != 3 {
+		t.Fatalf("Expected to give up after 3 attempts, got %v after %d calls", err, calls)
+	}
+}
+
+func TestSyncReportThreshold(t *testing.T) {
+	report := NewSyncReport(2)
+	report.Fail("acme", "internal", "com.acme", "widget", errors.New("one"))
+	report.Fail("acme", "internal", "com.acme", "widget", errors.New("two"))
+	if report.IsAborted() {
+		t.Fatalf("Errors for the same package should count once: %+v", report.Failures)
+	}
+	report.Fail("acme", "internal", "com.acme", "gadget", errors.New("three"))
+	if !report.IsAborted() {
+		t.Fatalf("Expected the run to abort after two failed packages: %+v", report.Failures)
+	}
+	if len(report.Failures[0].Errors) != 2 {
+		t.Errorf("Expected both errors collected against widget: %+v", report.Failures[0])
+	}
+}
+
+func newTestStorage(t *testing.T) *BoltStorage {
+	storage, err := NewStorage(Specification{DbFile: filepath.Join(t.TempDir(), "artifacts.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = storage.db.Close() })
+	return storage
+}
+
+func TestReconcile(t *testing.T) {
+	storage := newTestStorage(t)
+	artifact := func(version string, status Status) Artifact {
+		return Artifact{
+			ArtifactId: ArtifactId{Namespace: "com.acme", Package: "widget", Version: version},
+			Repository: "internal",
+			DomainName: "acme",
+			Format:     "maven",
+			Status:     status,
+			CreateTime: time.Now(),
+		}
+	}
+	_, err := storage.Insert(artifact("1.0.0", Published), artifact("1.1.0", Published), artifact("2.0.0", Unlisted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a status change moves the artifact rather than duplicating it
+	_, err = storage.Insert(artifact("1.1.0", Archived))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := NewSyncReport(0)
+	seen := map[ArtifactId]bool{artifact("1.1.0", Archived).ArtifactId: true}
+	reconcile(storage, Deleted, Scope{DomainName: "acme", Repository: "internal"}, seen, report)
+
+	if len(report.Reconciled) != 1 || len(report.Reconciled[0].Artifacts) != 2 {
+		t.Fatalf("Expected 1.0.0 and 2.0.0 to be reconciled: %+v", report.Reconciled)
+	}
+	deleted, err := storage.List([]Status{Deleted}, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 2 {
+		t.Errorf("Expected 2 deleted artifacts, got %+v", deleted)
+	}
+	all, err := storage.List(AllStatuses, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("Expected every artifact stored exactly once, got %+v", all)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	s := Specification{
+		Repos:     []string{"team-*", "shared"},
+		SkipRepos: []string{"*-store"},
+	}
+	for name, skip := range map[string]bool{
+		"team-billing":       false,
+		"shared":             false,
+		"team-billing-store": true,
+		"maven-central":      true,
+	} {
+		if s.Skip(name) != skip {
+			t.Errorf("Skip(%q) should be %v", name, skip)
+		}
+	}
+}
+
+func TestPackageFilters(t *testing.T) {
+	var filters PackageFilters
+	err := filters.Decode(`[
+		{"Repository": "shared-*", "Namespace": "com.acme.billing", "Formats": ["maven"]},
+		{"Repository": "shared-npm", "PackagePrefix": "billing-"}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(filters.For("internal")) != 0 {
+		t.Errorf("No filter should apply to internal")
+	}
+	if !filters.For("internal").Matches("npm", "", "anything") {
+		t.Errorf("Repositories without filters import everything")
+	}
+
+	maven := filters.For("shared-maven")
+	if !maven.Matches("maven", "com.acme.billing.api", "invoices") {
+		t.Errorf("Expected a namespace prefix match")
+	}
+	if maven.Matches("npm", "com.acme.billing", "invoices") || maven.Matches("maven", "com.acme.payroll", "invoices") {
+		t.Errorf("Expected format and namespace to be enforced")
+	}
+
+	npm := filters.For("shared-npm")
+	if len(npm) != 2 || !npm.Matches("npm", "", "billing-ui") {
+		t.Errorf("Expected either filter to admit a package: %+v", npm)
+	}
+}
+
+// staticImporter streams a fixed list of artifacts and claims to have listed its repository in full.
+type staticImporter struct {
+	artifacts []Artifact
+	scope     Scope
+}
+
+func (i staticImporter) Name() string {
+	return "static"
+}
+
+func (i staticImporter) Import(ctx context.Context, report *SyncReport, out chan<- Artifact) {
+	defer close(out)
+	for _, a := range i.artifacts {
+		out <- a
+	}
+	report.Complete(i.scope)
+}
+
+func TestLoadArtifacts(t *testing.T) {
+	storage := newTestStorage(t)
+	widget := func(version string) Artifact {
+		return Artifact{
+			ArtifactId: ArtifactId{Namespace: "com.acme", Package: "widget", Version: version},
+			Repository: "internal",
+			DomainName: "acme",
+			Format:     "maven",
+			Status:     Published,
+			CreateTime: time.Now(),
+		}
+	}
+	_, err := storage.Insert(widget("0.9.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	importers := []Importer{
+		staticImporter{
+			artifacts: []Artifact{widget("1.0.0"), widget("1.1.0")},
+			scope:     Scope{DomainName: "acme", Repository: "internal"},
+		},
+		staticImporter{
+			artifacts: []Artifact{{DomainName: "acme", Repository: "release", Error: errors.New("boom")}},
+			scope:     Scope{DomainName: "acme", Repository: "release"},
+		},
+	}
+	report := LoadArtifacts(context.Background(), importers, Specification{PageSize: 10, Reconcile: true, ReconcileStatus: Deleted}, storage)
+
+	if report.Inserted != 2 {
+		t.Errorf("Expected 2 inserted artifacts: %+v", report)
+	}
+	if len(report.Failures) != 1 || report.Failures[0].Repository != "release" {
+		t.Errorf("Expected the release failure to be reported: %+v", report.Failures)
+	}
+	if len(report.Reconciled) != 1 || report.Reconciled[0].Artifacts[0] != widget("0.9.0").ArtifactId {
+		t.Errorf("Expected only 0.9.0 in internal to be reconciled: %+v", report.Reconciled)
+	}
+}
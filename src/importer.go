@@ -0,0 +1,148 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// Importer is a source of artifacts for the catalog. LoadArtifacts batches and inserts whatever an importer
+// streams, so adding a package registry only means implementing Import.
+type Importer interface {
+	// Name identifies the importer in logs.
+	Name() string
+	// Import sends every artifact the source lists to out and closes it when done. Failures are sent as
+	// artifacts with Error set, identifying as much of the repository and package as is known. Importers
+	// should stop early once report.IsAborted or ctx is done, and call report.Complete for each repository
+	// listed in full.
+	Import(ctx context.Context, report *SyncReport, out chan<- Artifact)
+}
+
+// Scope is a repository an importer listed in full, narrowed by Filters and, for sources that can't enumerate
+// their packages, to the Packages that were asked for. Account and Region tell apart same-named CodeArtifact
+// domains, and are blank for other sources.
+type Scope struct {
+	DomainName string
+	Account    string
+	Region     string
+	Repository string
+	Filters    PackageFilters
+	Packages   []PackageRef
+}
+
+// PackageRef names a package without a version.
+type PackageRef struct {
+	Namespace string
+	Package   string
+}
+
+func (s Scope) covers(a Artifact) bool {
+	if !s.Filters.Matches(a.Format, a.Namespace, a.Package) {
+		return false
+	}
+	if s.Packages == nil {
+		return true
+	}
+	for _, p := range s.Packages {
+		if p.Namespace == a.Namespace && p.Package == a.Package {
+			return true
+		}
+	}
+	return false
+}
+
+type repositoryKey struct {
+	domain, account, region, repository string
+}
+
+func (s Scope) key() repositoryKey {
+	return repositoryKey{s.DomainName, s.Account, s.Region, s.Repository}
+}
+
+// location is where the copies the scope's repository holds are stored.
+func (s Scope) location() Location {
+	return Location{DomainName: s.DomainName, Account: s.Account, Region: s.Region, Repository: s.Repository}
+}
+
+// ImporterConfig configures one importer. Type selects the implementation; the remaining fields are read by
+// the types that need them.
+type ImporterConfig struct {
+	Type string
+	// Name is recorded as the DomainName of artifacts from registries outside CodeArtifact
+	Name string
+	// ImportTarget configures the codeartifact type
+	ImportTarget
+	// Url is the root of an HTTP registry, and Repository the name its artifacts are filed under, which
+	// defaults to the Url's host and path
+	Url        string
+	Repository string
+	Username   string
+	Password   string
+	Token      string
+	// Packages and Scopes name what to import from registries that can't list their contents
+	Packages []string
+	Scopes   []string
+	// Path is the directory read by the dir type, and Format limits it to one layout
+	Path   string
+	Format string
+}
+
+// ImporterConfigs is decoded from JSON, e.g.
+// ARTIFACTS_IMPORTERS='[{"Type":"codeartifact","Domain":"payments","Region":"eu-west-1"}]'
+type ImporterConfigs []ImporterConfig
+
+func (c *ImporterConfigs) Decode(value string) error {
+	return json.Unmarshal([]byte(value), (*[]ImporterConfig)(c))
+}
+
+var importerTypes = map[string]func(c ImporterConfig, s Specification) (Importer, error){
+	"codeartifact": newCodeArtifactImporter,
+	"maven":        newMavenImporter,
+	"npm":          newNpmImporter,
+	"pypi":         newPypiImporter,
+	"goproxy":      newGoProxyImporter,
+	"oci":          newOciImporter,
+	"dir":          newDirImporter,
+}
+
+func newCodeArtifactImporter(c ImporterConfig, s Specification) (Importer, error) {
+	if c.Domain == "" {
+		return nil, fmt.Errorf("codeartifact importer needs a Domain")
+	}
+	if c.Region == "" {
+		c.Region = s.Region
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = s.Endpoint
+	}
+	aux, err := NewCodeArtifactAux(s, c.ImportTarget)
+	if err != nil {
+		return nil, err
+	}
+	return &aux, nil
+}
+
+// NewImporters builds the importers configured by s.Importers. Without any, the CodeArtifact domains given by
+// s.ImportTargets are imported.
+func NewImporters(s Specification) ([]Importer, error) {
+	configs := s.Importers
+	if len(configs) == 0 {
+		for _, target := range s.ImportTargets() {
+			configs = append(configs, ImporterConfig{Type: "codeartifact", ImportTarget: target})
+		}
+	}
+
+	importers := make([]Importer, 0, len(configs))
+	for _, c := range configs {
+		constructor, ok := importerTypes[c.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown importer type %q", c.Type)
+		}
+		importer, err := constructor(c, s)
+		if err != nil {
+			return nil, err
+		}
+		importers = append(importers, importer)
+	}
+	return importers, nil
+}
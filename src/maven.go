@@ -0,0 +1,151 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MavenImporter walks a Maven 2 repository served over HTTP through its directory listings, importing the
+// versions listed by each artifact's maven-metadata.xml.
+type MavenImporter struct {
+	registry
+}
+
+func newMavenImporter(c ImporterConfig, s Specification) (Importer, error) {
+	r, err := newRegistry(c, s, "maven")
+	if err != nil {
+		return nil, err
+	}
+	return &MavenImporter{r}, nil
+}
+
+type mavenMetadata struct {
+	GroupId    string `xml:"groupId"`
+	ArtifactId string `xml:"artifactId"`
+	Versioning struct {
+		Versions    []string `xml:"versions>version"`
+		LastUpdated string   `xml:"lastUpdated"`
+	} `xml:"versioning"`
+}
+
+var hrefPattern = regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+)["']`)
+
+func (m *MavenImporter) Import(ctx context.Context, report *SyncReport, out chan<- Artifact) {
+	defer close(out)
+	report.count(1, 0, 0)
+
+	filters := m.PackageFilters.For(m.repository)
+	if m.walk(ctx, m.base, filters, report, out, make(map[string]bool)) {
+		report.Complete(Scope{DomainName: m.name, Repository: m.repository, Filters: filters})
+	}
+}
+
+// walk imports the artifact dir describes if it has a maven-metadata.xml listing versions, and otherwise
+// descends into its subdirectories. It reports whether everything below dir was read.
+func (m *MavenImporter) walk(ctx context.Context, dir *url.URL, filters PackageFilters, report *SyncReport, out chan<- Artifact, visited map[string]bool) bool {
+	if report.IsAborted() || ctx.Err() != nil {
+		return false
+	}
+	if visited[dir.Path] {
+		return true
+	}
+	visited[dir.Path] = true
+
+	body, _, err := m.get(ctx, dir.String(), "text/html")
+	if err != nil {
+		out <- Artifact{DomainName: m.name, Repository: m.repository, Error: fmt.Errorf("listing %s: %w", dir, err)}
+		return false
+	}
+
+	hasMetadata := false
+	subdirs := make([]*url.URL, 0)
+	for _, match := range hrefPattern.FindAllStringSubmatch(string(body), -1) {
+		link, err := url.Parse(html.UnescapeString(match[1]))
+		if err != nil {
+			continue
+		}
+		u := dir.ResolveReference(link)
+		u.RawQuery, u.Fragment = "", ""
+		// only ever walk down, never to parents or other hosts
+		if u.Host != m.base.Host || !strings.HasPrefix(u.Path, dir.Path) || u.Path == dir.Path {
+			continue
+		}
+		child := strings.TrimPrefix(u.Path, dir.Path)
+		if child == "maven-metadata.xml" {
+			hasMetadata = true
+		} else if strings.Index(child, "/") == len(child)-1 {
+			subdirs = append(subdirs, u)
+		}
+	}
+
+	if hasMetadata {
+		isArtifact, ok := m.artifact(ctx, dir, filters, report, out)
+		if isArtifact {
+			// the subdirectories are its versions
+			return ok
+		}
+	}
+
+	complete := true
+	for _, sub := range subdirs {
+		complete = m.walk(ctx, sub, filters, report, out, visited) && complete
+	}
+	return complete
+}
+
+// artifact imports the versions in dir's maven-metadata.xml. It reports whether the metadata described an
+// artifact, as opposed to a group, and whether it was read successfully.
+func (m *MavenImporter) artifact(ctx context.Context, dir *url.URL, filters PackageFilters, report *SyncReport, out chan<- Artifact) (bool, bool) {
+	metadataUrl := dir.ResolveReference(&url.URL{Path: "maven-metadata.xml"})
+	body, _, err := m.get(ctx, metadataUrl.String(), "application/xml")
+	if err != nil {
+		out <- Artifact{DomainName: m.name, Repository: m.repository, Error: err}
+		return true, false
+	}
+
+	metadata := mavenMetadata{}
+	err = xml.Unmarshal(body, &metadata)
+	if err != nil {
+		out <- Artifact{DomainName: m.name, Repository: m.repository, Error: fmt.Errorf("parsing %s: %w", metadataUrl, err)}
+		return true, false
+	}
+	if metadata.ArtifactId == "" || len(metadata.Versioning.Versions) == 0 {
+		return false, true
+	}
+	if !filters.Matches("maven", metadata.GroupId, metadata.ArtifactId) {
+		return true, true
+	}
+	report.count(0, 1, 0)
+
+	lastUpdated, err := time.Parse("20060102150405", metadata.Versioning.LastUpdated)
+	if err != nil {
+		lastUpdated = time.Now()
+	}
+
+	for _, version := range metadata.Versioning.Versions {
+		pom := dir.ResolveReference(&url.URL{Path: version + "/" + metadata.ArtifactId + "-" + version + ".pom"})
+		created := m.lastModified(ctx, pom.String())
+		if created.IsZero() {
+			created = lastUpdated
+		}
+		out <- Artifact{
+			ArtifactId: ArtifactId{
+				Namespace: metadata.GroupId,
+				Package:   metadata.ArtifactId,
+				Version:   version,
+			},
+			Repository: m.repository,
+			DomainName: m.name,
+			Format:     "maven",
+			Status:     Published,
+			CreateTime: created,
+		}
+	}
+	return true, true
+}
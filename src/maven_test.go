@@ -0,0 +1,78 @@
+package artifacts
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMavenImporter(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("testdata/maven")))
+	defer server.Close()
+
+	t.Run("imports every artifact", func(t *testing.T) {
+		storage := newTestStorage(t)
+		s := Specification{
+			PageSize:  10,
+			Importers: ImporterConfigs{{Type: "maven", Name: "legacy", Url: server.URL, Repository: "releases"}},
+		}
+		importers, err := NewImporters(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		report := LoadArtifacts(context.Background(), importers, s, storage)
+		if len(report.Failures) > 0 {
+			t.Fatalf("Unexpected failures %+v", report.Failures)
+		}
+
+		list, err := storage.List(AllStatuses, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := make(map[ArtifactId]Artifact)
+		for _, a := range list {
+			found[a.ArtifactId] = a
+		}
+		for _, id := range []ArtifactId{
+			{Namespace: "com.acme", Package: "widget", Version: "1.0.0"},
+			{Namespace: "com.acme", Package: "widget", Version: "1.1.0"},
+			{Namespace: "com.acme.tools", Package: "cli", Version: "2.0.0"},
+		} {
+			a, ok := found[id]
+			if !ok {
+				t.Errorf("Missing %+v in %+v", id, list)
+				continue
+			}
+			if a.Format != "maven" || a.Repository != "releases" || a.DomainName != "legacy" || a.CreateTime.IsZero() {
+				t.Errorf("Unexpected artifact %+v", a)
+			}
+		}
+		if len(list) != 3 {
+			t.Errorf("Expected 3 artifacts, got %+v", list)
+		}
+	})
+
+	t.Run("applies package filters", func(t *testing.T) {
+		storage := newTestStorage(t)
+		s := Specification{
+			PageSize:       10,
+			Importers:      ImporterConfigs{{Type: "maven", Url: server.URL, Repository: "releases"}},
+			PackageFilters: PackageFilters{{Repository: "releases", Namespace: "com.acme.tools"}},
+		}
+		importers, err := NewImporters(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		LoadArtifacts(context.Background(), importers, s, storage)
+		list, err := storage.List(AllStatuses, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != 1 || list[0].Package != "cli" {
+			t.Errorf("Expected only com.acme.tools:cli, got %+v", list)
+		}
+	})
+}
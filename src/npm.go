@@ -0,0 +1,244 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// NpmImporter reads packuments from an npm registry. npm registries can't list their packages, so it imports
+// the configured Packages plus whatever the registry's search finds in the configured Scopes.
+type NpmImporter struct {
+	registry
+	packages []string
+	scopes   []string
+}
+
+func newNpmImporter(c ImporterConfig, s Specification) (Importer, error) {
+	r, err := newRegistry(c, s, "npm")
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Packages) == 0 && len(c.Scopes) == 0 {
+		return nil, fmt.Errorf("npm importer needs Packages or Scopes")
+	}
+	return &NpmImporter{registry: r, packages: c.Packages, scopes: c.Scopes}, nil
+}
+
+type packument struct {
+	Name     string                `json:"name"`
+	Versions map[string]npmVersion `json:"versions"`
+	Time     map[string]string     `json:"time"`
+}
+
+type npmVersion struct {
+	Version     string          `json:"version"`
+	Description string          `json:"description"`
+	Deprecated  interface{}     `json:"deprecated"`
+	License     json.RawMessage `json:"license"`
+	Homepage    string          `json:"homepage"`
+	Repository  json.RawMessage `json:"repository"`
+	Dist        struct {
+		Integrity string `json:"integrity"`
+		Shasum    string `json:"shasum"`
+	} `json:"dist"`
+}
+
+type npmSearchResult struct {
+	Objects []struct {
+		Package struct {
+			Name string `json:"name"`
+		} `json:"package"`
+	} `json:"objects"`
+	Total int `json:"total"`
+}
+
+// splitNpmName splits @scope/name into the scope, without its @, and the name.
+func splitNpmName(name string) (string, string) {
+	if strings.HasPrefix(name, "@") {
+		if i := strings.Index(name, "/"); i > 0 {
+			return name[1:i], name[i+1:]
+		}
+	}
+	return "", name
+}
+
+func (n *NpmImporter) Import(ctx context.Context, report *SyncReport, out chan<- Artifact) {
+	defer close(out)
+	report.count(1, 0, 0)
+
+	names, complete := n.packageNames(ctx, out)
+	filters := n.PackageFilters.For(n.repository)
+	listed := make([]PackageRef, 0, len(names))
+	for _, name := range names {
+		if report.IsAborted() || ctx.Err() != nil {
+			return
+		}
+		namespace, pack := splitNpmName(name)
+		if !filters.Matches("npm", namespace, pack) {
+			continue
+		}
+		listed = append(listed, PackageRef{Namespace: namespace, Package: pack})
+		report.count(0, 1, 0)
+		if !n.importPackage(ctx, name, out) {
+			complete = false
+		}
+	}
+
+	if complete {
+		report.Complete(Scope{DomainName: n.name, Repository: n.repository, Filters: filters, Packages: listed})
+	}
+}
+
+// packageNames returns the configured packages and those found by searching the configured scopes. It
+// reports whether every search succeeded.
+func (n *NpmImporter) packageNames(ctx context.Context, out chan<- Artifact) ([]string, bool) {
+	found := make(map[string]bool)
+	names := make([]string, 0, len(n.packages))
+	add := func(name string) {
+		if !found[name] {
+			found[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, name := range n.packages {
+		add(name)
+	}
+
+	complete := true
+	for _, scope := range n.scopes {
+		scope = strings.TrimPrefix(scope, "@")
+		const size = 250
+		for from := 0; ; from += size {
+			query := url.Values{"text": {"scope:" + scope}, "size": {fmt.Sprint(size)}, "from": {fmt.Sprint(from)}}
+			u, err := n.resolve("-/v1/search?" + query.Encode())
+			if err != nil {
+				return names, false
+			}
+			body, _, err := n.get(ctx, u.String(), "application/json")
+			result := npmSearchResult{}
+			if err == nil {
+				err = json.Unmarshal(body, &result)
+			}
+			if err != nil {
+				out <- Artifact{DomainName: n.name, Repository: n.repository, ArtifactId: ArtifactId{Namespace: scope}, Error: fmt.Errorf("searching scope %s: %w", scope, err)}
+				complete = false
+				break
+			}
+			for _, o := range result.Objects {
+				// search matches loosely, so keep only the scope's own packages
+				if namespace, _ := splitNpmName(o.Package.Name); namespace == scope {
+					add(o.Package.Name)
+				}
+			}
+			if len(result.Objects) == 0 || from+size >= result.Total {
+				break
+			}
+		}
+	}
+	return names, complete
+}
+
+// importPackage fetches the packument for name and streams its versions. It reports whether it succeeded.
+func (n *NpmImporter) importPackage(ctx context.Context, name string, out chan<- Artifact) bool {
+	namespace, pack := splitNpmName(name)
+	fail := func(err error) bool {
+		out <- Artifact{
+			ArtifactId: ArtifactId{Namespace: namespace, Package: pack},
+			Repository: n.repository,
+			DomainName: n.name,
+			Error:      err,
+		}
+		return false
+	}
+
+	// scoped names are requested as @scope%2fname
+	u, err := n.resolve(url.PathEscape(name))
+	if err != nil {
+		return fail(err)
+	}
+	body, _, err := n.get(ctx, u.String(), "application/json")
+	if err != nil {
+		return fail(err)
+	}
+	doc := packument{}
+	err = json.Unmarshal(body, &doc)
+	if err != nil {
+		return fail(fmt.Errorf("parsing packument for %s: %w", name, err))
+	}
+
+	for v, version := range doc.Versions {
+		status := Published
+		if deprecated(version.Deprecated) {
+			status = Unlisted
+		}
+		created, err := time.Parse(time.RFC3339, doc.Time[v])
+		if err != nil {
+			created = time.Now()
+		}
+		revision := version.Dist.Integrity
+		if revision == "" {
+			revision = version.Dist.Shasum
+		}
+		out <- Artifact{
+			ArtifactId: ArtifactId{
+				Namespace: namespace,
+				Package:   pack,
+				Version:   v,
+			},
+			Repository:           n.repository,
+			DomainName:           n.name,
+			Revision:             revision,
+			Format:               "npm",
+			Status:               status,
+			CreateTime:           created,
+			Summary:              version.Description,
+			Licenses:             npmLicenses(version.License),
+			HomePage:             version.Homepage,
+			SourceCodeRepository: npmRepository(version.Repository),
+		}
+	}
+	return true
+}
+
+// deprecated reads a version's deprecated field, a message when set, though some registries write booleans.
+func deprecated(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return v != ""
+	case bool:
+		return v
+	}
+	return false
+}
+
+// npmLicenses reads a license that is either an SPDX expression or a legacy {"type": ...} object.
+func npmLicenses(raw json.RawMessage) []string {
+	var expression string
+	if json.Unmarshal(raw, &expression) == nil && expression != "" {
+		return []string{expression}
+	}
+	var legacy struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(raw, &legacy) == nil && legacy.Type != "" {
+		return []string{legacy.Type}
+	}
+	return nil
+}
+
+// npmRepository reads a repository that is either a url or a {"type": ..., "url": ...} object.
+func npmRepository(raw json.RawMessage) string {
+	var u string
+	if json.Unmarshal(raw, &u) == nil {
+		return u
+	}
+	var repository struct {
+		Url string `json:"url"`
+	}
+	_ = json.Unmarshal(raw, &repository)
+	return repository.Url
+}
@@ -0,0 +1,81 @@
+package artifacts
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNpmImporter(t *testing.T) {
+	packuments := map[string]string{
+		"/@acme/ui": `{
+			"name": "@acme/ui",
+			"versions": {
+				"1.0.0": {"version": "1.0.0", "deprecated": "use 2.x", "license": "MIT", "dist": {"shasum": "abc"}},
+				"2.0.0": {"version": "2.0.0", "description": "Buttons", "repository": {"type": "git", "url": "git+https://example.com/ui.git"}}
+			},
+			"time": {"created": "2021-01-01T00:00:00.000Z", "1.0.0": "2021-01-01T00:00:00.000Z", "2.0.0": "2021-06-01T00:00:00.000Z"}
+		}`,
+		"/left-pad": `{"name": "left-pad", "versions": {"1.3.0": {"version": "1.3.0", "license": {"type": "WTFPL"}}}, "time": {}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/-/v1/search" {
+			if r.URL.Query().Get("text") != "scope:acme" {
+				t.Errorf("Unexpected search %s", r.URL.RawQuery)
+			}
+			_, _ = w.Write([]byte(`{"objects": [{"package": {"name": "@acme/ui"}}, {"package": {"name": "acme-unrelated"}}], "total": 2}`))
+			return
+		}
+		body, ok := packuments[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	storage := newTestStorage(t)
+	s := Specification{
+		PageSize:  10,
+		Importers: ImporterConfigs{{Type: "npm", Url: server.URL, Repository: "npm-private", Packages: []string{"left-pad"}, Scopes: []string{"@acme"}}},
+	}
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := LoadArtifacts(context.Background(), importers, s, storage)
+	if len(report.Failures) > 0 {
+		t.Fatalf("Unexpected failures %+v", report.Failures)
+	}
+
+	list, err := storage.List(AllStatuses, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := make(map[ArtifactId]Artifact)
+	for _, a := range list {
+		found[a.ArtifactId] = a
+	}
+	if len(found) != 3 {
+		t.Fatalf("Expected 3 versions, got %+v", list)
+	}
+
+	deprecated := found[ArtifactId{Namespace: "acme", Package: "ui", Version: "1.0.0"}]
+	if deprecated.Status != Unlisted || deprecated.Format != "npm" || deprecated.Licenses[0] != "MIT" {
+		t.Errorf("Expected a deprecated scoped version to be Unlisted: %+v", deprecated)
+	}
+	current := found[ArtifactId{Namespace: "acme", Package: "ui", Version: "2.0.0"}]
+	if current.Status != Published || !current.CreateTime.Equal(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the publish time from the packument: %+v", current)
+	}
+	if current.SourceCodeRepository != "git+https://example.com/ui.git" {
+		t.Errorf("Expected the repository url: %+v", current)
+	}
+	unscoped := found[ArtifactId{Package: "left-pad", Version: "1.3.0"}]
+	if unscoped.Status != Published || unscoped.Licenses[0] != "WTFPL" {
+		t.Errorf("Expected the unscoped package without a namespace: %+v", unscoped)
+	}
+}
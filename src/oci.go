@@ -0,0 +1,221 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// OciImporter reads container image tags from a registry speaking the OCI distribution API. Each tag is an
+// artifact whose Revision is its manifest digest. Repositories come from /v2/_catalog unless Packages names
+// them.
+type OciImporter struct {
+	registry
+	repositories []string
+}
+
+func newOciImporter(c ImporterConfig, s Specification) (Importer, error) {
+	r, err := newRegistry(c, s, "oci")
+	if err != nil {
+		return nil, err
+	}
+	return &OciImporter{registry: r, repositories: c.Packages}, nil
+}
+
+// ociManifestTypes are the manifest media types asked for, so the digest matches what clients pull.
+var ociManifestTypes = strings.Join([]string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}, ", ")
+
+var nextLinkPattern = regexp.MustCompile(`<([^>]+)>\s*;\s*rel="?next"?`)
+
+func (o *OciImporter) Import(ctx context.Context, report *SyncReport, out chan<- Artifact) {
+	defer close(out)
+	report.count(1, 0, 0)
+
+	var listed []PackageRef
+	repositories := o.repositories
+	if len(repositories) == 0 {
+		var err error
+		repositories, err = o.catalog(ctx)
+		if err != nil {
+			out <- Artifact{DomainName: o.name, Repository: o.repository, Error: err}
+			return
+		}
+	} else {
+		// only the catalog covers every repository
+		listed = make([]PackageRef, 0, len(repositories))
+	}
+
+	filters := o.PackageFilters.For(o.repository)
+	complete := true
+	for _, name := range repositories {
+		if report.IsAborted() || ctx.Err() != nil {
+			return
+		}
+		namespace, pack := splitImageName(name)
+		if !filters.Matches("oci", namespace, pack) {
+			continue
+		}
+		if listed != nil {
+			listed = append(listed, PackageRef{Namespace: namespace, Package: pack})
+		}
+		report.count(0, 1, 0)
+		err := o.importRepository(ctx, name, out)
+		if err != nil {
+			out <- Artifact{ArtifactId: ArtifactId{Namespace: namespace, Package: pack}, DomainName: o.name, Repository: o.repository, Error: err}
+			complete = false
+		}
+	}
+
+	if complete {
+		report.Complete(Scope{DomainName: o.name, Repository: o.repository, Filters: filters, Packages: listed})
+	}
+}
+
+// splitImageName splits an image repository name like team/service/api into team/service and api.
+func splitImageName(name string) (string, string) {
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return "", name
+	}
+	return name[:i], name[i+1:]
+}
+
+// pages follows the Link headers of a paginated listing, calling page with the body of each page.
+func (o *OciImporter) pages(ctx context.Context, ref string, page func(body []byte) error) error {
+	u, err := o.resolve(ref)
+	if err != nil {
+		return err
+	}
+	for u != nil {
+		body, header, err := o.get(ctx, u.String(), "application/json")
+		if err != nil {
+			return err
+		}
+		err = page(body)
+		if err != nil {
+			return fmt.Errorf("parsing %s: %w", u, err)
+		}
+
+		next := nextLinkPattern.FindStringSubmatch(header.Get("Link"))
+		if next == nil {
+			break
+		}
+		link, err := url.Parse(next[1])
+		if err != nil {
+			return err
+		}
+		u = u.ResolveReference(link)
+	}
+	return nil
+}
+
+func (o *OciImporter) catalog(ctx context.Context) ([]string, error) {
+	repositories := make([]string, 0)
+	err := o.pages(ctx, fmt.Sprintf("v2/_catalog?n=%d", o.PageSize), func(body []byte) error {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		err := json.Unmarshal(body, &page)
+		repositories = append(repositories, page.Repositories...)
+		return err
+	})
+	return repositories, err
+}
+
+func (o *OciImporter) tags(ctx context.Context, name string) ([]string, error) {
+	tags := make([]string, 0)
+	err := o.pages(ctx, fmt.Sprintf("v2/%s/tags/list?n=%d", name, o.PageSize), func(body []byte) error {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		err := json.Unmarshal(body, &page)
+		tags = append(tags, page.Tags...)
+		return err
+	})
+	return tags, err
+}
+
+// manifest returns the digest of the manifest a tag points to and when the registry says it was last
+// modified, if it does.
+func (o *OciImporter) manifest(ctx context.Context, name, tag string) (string, time.Time, error) {
+	u, err := o.resolve(fmt.Sprintf("v2/%s/manifests/%s", name, tag))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	var digest string
+	var modified time.Time
+	err = retry(ctx, o.Retries, o.RetryBackoff, func() error {
+		response, err := o.request(ctx, http.MethodHead, u.String(), ociManifestTypes)
+		if err != nil {
+			return err
+		}
+		_ = response.Body.Close()
+		if response.StatusCode >= 300 {
+			return &HTTPStatusError{Url: u.String(), StatusCode: response.StatusCode}
+		}
+		digest = response.Header.Get("Docker-Content-Digest")
+		modified, _ = http.ParseTime(response.Header.Get("Last-Modified"))
+		return nil
+	})
+	if err != nil || digest != "" {
+		return digest, modified, err
+	}
+
+	// the digest header is optional, so hash the manifest ourselves
+	response, err := o.request(ctx, http.MethodGet, u.String(), ociManifestTypes)
+	if err != nil {
+		return "", modified, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode >= 300 {
+		return "", modified, &HTTPStatusError{Url: u.String(), StatusCode: response.StatusCode}
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, response.Body)
+	return fmt.Sprintf("sha256:%x", hash.Sum(nil)), modified, err
+}
+
+func (o *OciImporter) importRepository(ctx context.Context, name string, out chan<- Artifact) error {
+	tags, err := o.tags(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	namespace, pack := splitImageName(name)
+	for _, tag := range tags {
+		digest, modified, err := o.manifest(ctx, name, tag)
+		if err != nil {
+			return err
+		}
+		if modified.IsZero() {
+			modified = time.Now()
+		}
+		out <- Artifact{
+			ArtifactId: ArtifactId{
+				Namespace: namespace,
+				Package:   pack,
+				Version:   tag,
+			},
+			Repository: o.repository,
+			DomainName: o.name,
+			Revision:   digest,
+			Format:     "oci",
+			Status:     Published,
+			CreateTime: modified,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package artifacts
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// registryStandIn serves just enough of the OCI distribution API for the importer, paginating the catalog one
+// repository at a time.
+func registryStandIn(t *testing.T) *httptest.Server {
+	manifest := []byte(`{"schemaVersion": 2}`)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/_catalog", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", `</v2/_catalog?last=payments%2Fapi&n=1>; rel="next"`)
+			_, _ = w.Write([]byte(`{"repositories": ["payments/api"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"repositories": ["base"]}`))
+	})
+	mux.HandleFunc("/v2/payments/api/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name": "payments/api", "tags": ["1.0.0", "latest"]}`))
+	})
+	mux.HandleFunc("/v2/base/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name": "base", "tags": ["3.14"]}`))
+	})
+	mux.HandleFunc("/v2/payments/api/manifests/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Content-Digest", "sha256:"+r.URL.Path[len(r.URL.Path)-5:])
+		w.Header().Set("Last-Modified", "Mon, 01 Nov 2021 09:00:00 GMT")
+	})
+	mux.HandleFunc("/v2/base/manifests/3.14", func(w http.ResponseWriter, r *http.Request) {
+		// no digest header, so the importer has to hash the manifest
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(manifest)
+		}
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestOciImporter(t *testing.T) {
+	server := registryStandIn(t)
+	defer server.Close()
+
+	storage := newTestStorage(t)
+	s := Specification{
+		PageSize:  1,
+		Importers: ImporterConfigs{{Type: "oci", Name: "containers", Url: server.URL, Repository: "registry"}},
+	}
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := LoadArtifacts(context.Background(), importers, s, storage)
+	if len(report.Failures) > 0 {
+		t.Fatalf("Unexpected failures %+v", report.Failures)
+	}
+
+	list, err := storage.List(AllStatuses, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revisions := make(map[ArtifactId]string)
+	for _, a := range list {
+		if a.Format != "oci" || a.Repository != "registry" || a.DomainName != "containers" {
+			t.Errorf("Unexpected artifact %+v", a)
+		}
+		revisions[a.ArtifactId] = a.Revision
+	}
+
+	expected := map[ArtifactId]string{
+		{Namespace: "payments", Package: "api", Version: "1.0.0"}:  "sha256:1.0.0",
+		{Namespace: "payments", Package: "api", Version: "latest"}: "sha256:atest",
+		{Package: "base", Version: "3.14"}:                         fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(`{"schemaVersion": 2}`))),
+	}
+	for id, digest := range expected {
+		if revisions[id] != digest {
+			t.Errorf("Expected %+v to have digest %s, got %+v", id, digest, revisions)
+		}
+	}
+	if len(revisions) != len(expected) {
+		t.Errorf("Expected %d tags, got %+v", len(expected), revisions)
+	}
+}
@@ -0,0 +1,406 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact/types"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var originsBucket = []byte("origins")
+
+var (
+	errNotOriginEditor = errors.New("only origin editors can change package origin controls")
+	errNoOriginTargets = errors.New("origin changes need a namespace or packages")
+)
+
+// OriginRestrictions are the values Publish and Upstream can take.
+var OriginRestrictions = []string{string(types.AllowPublishAllow), string(types.AllowPublishBlock)}
+
+// PackageOrigin is a package's origin controls in one repository: whether versions may be published to the
+// repository directly (Publish), and whether they may be fetched from upstreams and external connections
+// (Upstream), each ALLOW or BLOCK. Blocking one of them is how a package is defended against dependency
+// confusion.
+type PackageOrigin struct {
+	DomainName string
+	Repository string
+	Format     string
+	Namespace  string `json:",omitempty"`
+	Package    string
+	Publish    string
+	Upstream   string
+	// UpdatedBy is who last changed the controls from the catalog, if anyone
+	UpdatedBy string    `json:",omitempty"`
+	UpdatedAt time.Time `json:",omitempty"`
+}
+
+// key orders origins by repository, then format and namespace, so a namespace's packages are stored together.
+func (o PackageOrigin) key() []byte {
+	return []byte(strings.Join([]string{o.DomainName, o.Repository, o.Format, o.Namespace, o.Package}, "\x00"))
+}
+
+func packageOrigin(domain, repository string, p *types.PackageSummary) (PackageOrigin, bool) {
+	if p == nil || p.OriginConfiguration == nil || p.OriginConfiguration.Restrictions == nil {
+		return PackageOrigin{}, false
+	}
+	return PackageOrigin{
+		DomainName: domain,
+		Repository: repository,
+		Format:     string(p.Format),
+		Namespace:  aws.ToString(p.Namespace),
+		Package:    aws.ToString(p.Package),
+		Publish:    string(p.OriginConfiguration.Restrictions.Publish),
+		Upstream:   string(p.OriginConfiguration.Restrictions.Upstream),
+	}, true
+}
+
+// recordOrigins stores the origin controls an import listed. Who last changed them from the catalog is kept.
+func recordOrigins(report *SyncReport, origins []PackageOrigin) {
+	if report.settings == nil || len(origins) == 0 {
+		return
+	}
+	if err := report.settings.SaveOrigins(true, origins...); err != nil {
+		log.Error().Err(err).Int("packages", len(origins)).Msg("Failed to store package origin controls")
+	}
+}
+
+// SaveOrigins stores package origin controls, replacing what is stored for the same packages. With keepEditor
+// set, who last changed a package's controls is carried over.
+func (rs *BoltStorage) SaveOrigins(keepEditor bool, origins ...PackageOrigin) error {
+	return rs.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(originsBucket)
+		if err != nil {
+			return err
+		}
+		for _, o := range origins {
+			if v := bucket.Get(o.key()); keepEditor && v != nil {
+				stored := PackageOrigin{}
+				if err := json.Unmarshal(v, &stored); err != nil {
+					return err
+				}
+				o.UpdatedBy, o.UpdatedAt = stored.UpdatedBy, stored.UpdatedAt
+			}
+			value, err := json.Marshal(o)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(o.key(), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// OriginQuery selects stored origin controls. Blank fields match anything.
+type OriginQuery struct {
+	DomainName string
+	Repository string
+	Format     string
+	Namespace  string
+	// Packages, when given, are the only packages matched
+	Packages []string
+}
+
+func (q OriginQuery) matches(o PackageOrigin) bool {
+	if q.DomainName != "" && q.DomainName != o.DomainName {
+		return false
+	}
+	if q.Repository != "" && q.Repository != o.Repository {
+		return false
+	}
+	if q.Format != "" && q.Format != o.Format {
+		return false
+	}
+	if q.Namespace != "" && q.Namespace != o.Namespace {
+		return false
+	}
+	if len(q.Packages) == 0 {
+		return true
+	}
+	for _, p := range q.Packages {
+		if p == o.Package {
+			return true
+		}
+	}
+	return false
+}
+
+// Origins returns the stored origin controls q matches, by repository, format, namespace and package.
+func (rs *BoltStorage) Origins(q OriginQuery) ([]PackageOrigin, error) {
+	origins := make([]PackageOrigin, 0)
+	err := rs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(originsBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			o := PackageOrigin{}
+			if err := json.Unmarshal(v, &o); err != nil {
+				return err
+			}
+			if q.matches(o) {
+				origins = append(origins, o)
+			}
+			return nil
+		})
+	})
+	return origins, err
+}
+
+// OriginChange sets Publish and Upstream for Packages of Namespace in a repository, or for every package of
+// Namespace the catalog knows of when Packages is empty. DomainName may be left out when only one domain is
+// imported.
+type OriginChange struct {
+	DomainName string
+	Repository string
+	Format     string
+	Namespace  string
+	Packages   []string
+	Publish    string
+	Upstream   string
+}
+
+// OriginOutcome is what became of one package's origin controls.
+type OriginOutcome struct {
+	PackageOrigin
+	Updated bool
+	Error   string `json:",omitempty"`
+}
+
+func validRestriction(value string) bool {
+	for _, r := range OriginRestrictions {
+		if value == r {
+			return true
+		}
+	}
+	return false
+}
+
+// canEditOrigins reports whether user is one of editors.
+func canEditOrigins(editors []string, user string) bool {
+	for _, editor := range editors {
+		if user != "" && editor == user {
+			return true
+		}
+	}
+	return false
+}
+
+// ChangeOrigins applies change with PutPackageOriginConfiguration, package by package, storing the controls
+// CodeArtifact reports back. Only editors may make changes.
+func ChangeOrigins(ctx context.Context, domains Domains, session *BoltStorage, editors []string, user string, change OriginChange) ([]OriginOutcome, error) {
+	if user == "" {
+		return nil, errNoUser
+	}
+	if !canEditOrigins(editors, user) {
+		return nil, errNotOriginEditor
+	}
+	if !validRestriction(change.Publish) || !validRestriction(change.Upstream) {
+		return nil, fmt.Errorf("publish and upstream must each be one of %s", strings.Join(OriginRestrictions, ", "))
+	}
+	if change.Repository == "" || change.Format == "" {
+		return nil, fmt.Errorf("origin changes need a repository and format")
+	}
+	if change.Namespace == "" && len(change.Packages) == 0 {
+		return nil, errNoOriginTargets
+	}
+	if change.DomainName == "" && len(domains) == 1 {
+		change.DomainName = domains[0].Target.Domain
+	}
+	wrapper, err := domains.Named(change.DomainName)
+	if err != nil {
+		return nil, err
+	}
+
+	packages := change.Packages
+	if len(packages) == 0 {
+		known, err := session.Origins(OriginQuery{DomainName: change.DomainName, Repository: change.Repository, Format: change.Format, Namespace: change.Namespace})
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range known {
+			packages = append(packages, o.Package)
+		}
+		if len(packages) == 0 {
+			return nil, fmt.Errorf("no packages of %s are known in %s", change.Namespace, change.Repository)
+		}
+	}
+
+	now := time.Now()
+	outcomes := make([]OriginOutcome, 0, len(packages))
+	changed := make([]PackageOrigin, 0, len(packages))
+	for _, pack := range packages {
+		outcome := OriginOutcome{PackageOrigin: PackageOrigin{
+			DomainName: change.DomainName,
+			Repository: change.Repository,
+			Format:     change.Format,
+			Namespace:  change.Namespace,
+			Package:    pack,
+			Publish:    change.Publish,
+			Upstream:   change.Upstream,
+			UpdatedBy:  user,
+			UpdatedAt:  now,
+		}}
+		restrictions, err := wrapper.PutOrigin(ctx, change.Repository, change.Format, change.Namespace, pack, change.Publish, change.Upstream)
+		if err != nil {
+			outcome.Error = err.Error()
+			outcomes = append(outcomes, outcome)
+			continue
+		}
+		outcome.Publish = string(restrictions.Publish)
+		outcome.Upstream = string(restrictions.Upstream)
+		outcome.Updated = true
+		outcomes = append(outcomes, outcome)
+		changed = append(changed, outcome.PackageOrigin)
+	}
+	if err := session.SaveOrigins(false, changed...); err != nil {
+		return outcomes, err
+	}
+
+	log.Info().Str("user", user).Interface("change", change).Interface("outcomes", outcomes).Msg("Changed package origin controls")
+	return outcomes, nil
+}
+
+func originErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errNoUser):
+		return http.StatusUnauthorized
+	case errors.Is(err, errNotOriginEditor):
+		return http.StatusForbidden
+	case errors.Is(err, ErrUnknownDomain):
+		return http.StatusNotFound
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+type OriginsHtmlContext struct {
+	Query        OriginQuery
+	Origins      []PackageOrigin
+	Outcomes     []OriginOutcome
+	Restrictions []string
+	// Editable is whether the user may change origin controls
+	Editable bool
+}
+
+func originQuery(values map[string][]string) OriginQuery {
+	get := func(key string) string {
+		if v := values[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	return OriginQuery{
+		DomainName: get("domain"),
+		Repository: get("repository"),
+		Format:     get("format"),
+		Namespace:  get("namespace"),
+		Packages:   values["package"],
+	}
+}
+
+// OriginRoutes serves GET /origins, the stored package origin controls, filtered by the domain, repository,
+// format, namespace and package query parameters, and POST /origins, which changes them from a JSON
+// OriginChange or the page's form, which must be posted from the catalog's own pages. Changes are limited to
+// s.OriginEditors.
+func OriginRoutes(s Specification, domains Domains, session *BoltStorage) Routes {
+	return func(r *mux.Router) {
+		r.Methods("GET").Headers("Content-Type", "application/json").Path("/origins").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			origins, err := session.Origins(originQuery(request.URL.Query()))
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			jsonObjects, err := json.Marshal(origins)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			_, _ = writer.Write(jsonObjects)
+		})
+
+		r.Methods("GET").Path("/origins").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			query := originQuery(request.URL.Query())
+			origins, err := session.Origins(query)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			renderTemplate(writer, "origins", OriginsHtmlContext{
+				Query:        query,
+				Origins:      origins,
+				Restrictions: OriginRestrictions,
+				Editable:     canEditOrigins(s.OriginEditors, request.Header.Get(s.UserHeader)),
+			})
+		})
+
+		r.Methods("POST").Headers("Content-Type", "application/json").Path("/origins").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			change := OriginChange{}
+			if err := json.NewDecoder(request.Body).Decode(&change); err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			outcomes, err := ChangeOrigins(request.Context(), domains, session, s.OriginEditors, request.Header.Get(s.UserHeader), change)
+			if err != nil {
+				http.Error(writer, err.Error(), originErrorStatus(err))
+				return
+			}
+			jsonObjects, err := json.Marshal(outcomes)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			_, _ = writer.Write(jsonObjects)
+		})
+
+		r.Methods("POST").Path("/origins").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if !sameSite(request) {
+				http.Error(writer, "Forms must be posted from the catalog", http.StatusForbidden)
+				return
+			}
+			if err := request.ParseForm(); err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			query := originQuery(request.PostForm)
+			user := request.Header.Get(s.UserHeader)
+			outcomes, err := ChangeOrigins(request.Context(), domains, session, s.OriginEditors, user, OriginChange{
+				DomainName: query.DomainName,
+				Repository: query.Repository,
+				Format:     query.Format,
+				Namespace:  query.Namespace,
+				Packages:   query.Packages,
+				Publish:    request.PostForm.Get("publish"),
+				Upstream:   request.PostForm.Get("upstream"),
+			})
+			if err != nil {
+				http.Error(writer, err.Error(), originErrorStatus(err))
+				return
+			}
+			query.Packages = nil
+			origins, err := session.Origins(query)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			renderTemplate(writer, "origins", OriginsHtmlContext{
+				Query:        query,
+				Origins:      origins,
+				Outcomes:     outcomes,
+				Restrictions: OriginRestrictions,
+				Editable:     canEditOrigins(s.OriginEditors, user),
+			})
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestChangeOrigins(t *testing.T) {
+	server, s := fakeCodeArtifact(t)
+	s.UserHeader = "X-Forwarded-User"
+	s.OriginEditors = []string{"alice"}
+	storage := newTestStorage(t)
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadArtifacts(context.Background(), importers, s, storage)
+	domains := CodeArtifactDomains(importers)
+
+	imported, err := storage.Origins(OriginQuery{Repository: "internal", Namespace: "com.acme"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 2 || imported[0].Publish != "ALLOW" || imported[0].Upstream != "ALLOW" {
+		t.Fatalf("Expected the origin controls of widget and gadget, got %+v", imported)
+	}
+
+	block := OriginChange{Repository: "internal", Format: "maven", Namespace: "com.acme", Publish: "ALLOW", Upstream: "BLOCK"}
+	if _, err := ChangeOrigins(context.Background(), domains, storage, s.OriginEditors, "mallory", block); err != errNotOriginEditor {
+		t.Errorf("Expected users who aren't editors to be refused, got %v", err)
+	}
+	if _, err := ChangeOrigins(context.Background(), domains, storage, s.OriginEditors, "", block); err != errNoUser {
+		t.Errorf("Expected anonymous users to be refused, got %v", err)
+	}
+	if _, err := ChangeOrigins(context.Background(), domains, storage, s.OriginEditors, "alice", OriginChange{Repository: "internal", Format: "maven", Publish: "ALLOW", Upstream: "BLOCK"}); err != errNoOriginTargets {
+		t.Errorf("Expected a change without a namespace or packages to be refused, got %v", err)
+	}
+
+	outcomes, err := ChangeOrigins(context.Background(), domains, storage, s.OriginEditors, "alice", block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(outcomes) != 2 || !outcomes[0].Updated || !outcomes[1].Updated {
+		t.Errorf("Expected both packages of the namespace to change, got %+v", outcomes)
+	}
+	for _, name := range []string{"widget", "gadget"} {
+		if p, _ := server.Package("internal", "maven", "com.acme", name); p.Upstream != "BLOCK" {
+			t.Errorf("Expected CodeArtifact to block upstreams of %s, got %+v", name, p)
+		}
+	}
+
+	// a re-import keeps who made the change
+	LoadArtifacts(context.Background(), importers, s, storage)
+	stored, _ := storage.Origins(OriginQuery{Repository: "internal", Packages: []string{"widget"}})
+	if len(stored) != 1 || stored[0].Upstream != "BLOCK" || stored[0].UpdatedBy != "alice" {
+		t.Errorf("Expected widget's change by alice to be stored, got %+v", stored)
+	}
+
+	LoadTemplates(Specification{Templates: "templates/"})
+	router := initRouting(storage, OriginRoutes(s, domains, storage))
+	body, _ := json.Marshal(OriginChange{Repository: "release", Format: "npm", Namespace: "acme", Packages: []string{"client", "missing"}, Publish: "BLOCK", Upstream: "ALLOW"})
+	request := httptest.NewRequest("POST", "/origins", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Forwarded-User", "alice")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	served := make([]OriginOutcome, 0)
+	if err := json.NewDecoder(response.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 2 || !served[0].Updated || served[0].Publish != "BLOCK" || served[1].Updated || served[1].Error == "" {
+		t.Errorf("Expected client to change and missing to fail, got %+v", served)
+	}
+
+	form := url.Values{"repository": {"internal"}, "format": {"maven"}, "namespace": {"com.acme"}, "package": {"gadget"}, "publish": {"ALLOW"}, "upstream": {"ALLOW"}}
+	request = httptest.NewRequest("POST", "/origins", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("X-Forwarded-User", "mallory")
+	request.Header.Set("Origin", "http://example.com")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != 403 {
+		t.Errorf("Expected the form to refuse users who aren't editors, got %d", response.Code)
+	}
+
+	request = httptest.NewRequest("POST", "/origins", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("X-Forwarded-User", "alice")
+	request.Header.Set("Origin", "https://attacker.example")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != 403 {
+		t.Errorf("Expected a form posted from another site to be refused, got %d", response.Code)
+	}
+	if p, _ := server.Package("internal", "maven", "com.acme", "gadget"); p.Upstream != "BLOCK" {
+		t.Errorf("Expected gadget's upstreams to stay blocked, got %+v", p)
+	}
+
+	request = httptest.NewRequest("POST", "/origins", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("X-Forwarded-User", "alice")
+	request.Header.Set("Origin", "http://example.com")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != 200 || !strings.Contains(response.Body.String(), "Change origin controls") {
+		t.Errorf("Expected alice's form to change gadget, got %d %s", response.Code, response.Body)
+	}
+	if p, _ := server.Package("internal", "maven", "com.acme", "gadget"); p.Upstream != "ALLOW" {
+		t.Errorf("Expected gadget's upstreams to be allowed, got %+v", p)
+	}
+
+	request = httptest.NewRequest("GET", "/origins?namespace=com.acme", nil)
+	request.Header.Set("X-Forwarded-User", "alice")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if page := response.Body.String(); !strings.Contains(page, `name="package" value="widget"`) || !strings.Contains(page, "Change origin controls") {
+		t.Errorf("Expected editors to be offered changes, got %s", page)
+	}
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/origins?namespace=com.acme", nil))
+	if page := response.Body.String(); strings.Contains(page, "Change origin controls") || !strings.Contains(page, "BLOCK") {
+		t.Errorf("Expected everyone else to only see the controls, got %s", page)
+	}
+}
@@ -0,0 +1,278 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var promotionsBucket = []byte("promotions")
+
+// Promotion is the latest attempt to copy an artifact from its repository to Destination.
+type Promotion struct {
+	Source      string
+	Destination string
+	Promoted    bool
+	Error       string `json:",omitempty"`
+	By          string `json:",omitempty"`
+	At          time.Time
+}
+
+// PromotionRequest asks for the named artifacts to be copied to Destination. AllowOverwrite and
+// IncludeFromUpstream are passed on to CopyPackageVersions.
+type PromotionRequest struct {
+	Destination         string
+	Artifacts           []string
+	AllowOverwrite      bool
+	IncludeFromUpstream bool
+}
+
+// PromotionOutcome is what became of one artifact asked to be promoted.
+type PromotionOutcome struct {
+	Ref string
+	Promotion
+}
+
+// RecordPromotion stores p as the artifact's promotion to p.Destination, replacing any earlier one.
+func (rs *BoltStorage) RecordPromotion(id ArtifactId, p Promotion) error {
+	key, err := id.Key()
+	if err != nil {
+		return err
+	}
+	return rs.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(promotionsBucket)
+		if err != nil {
+			return err
+		}
+		promotions := make([]Promotion, 0, 1)
+		if v := bucket.Get(key); v != nil {
+			if err := json.Unmarshal(v, &promotions); err != nil {
+				return err
+			}
+		}
+		replaced := false
+		for i := range promotions {
+			if promotions[i].Destination == p.Destination {
+				promotions[i] = p
+				replaced = true
+			}
+		}
+		if !replaced {
+			promotions = append(promotions, p)
+		}
+		value, err := json.Marshal(promotions)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, value)
+	})
+}
+
+// Promotions returns the artifact's promotions, one per destination repository.
+func (rs *BoltStorage) Promotions(id ArtifactId) ([]Promotion, error) {
+	key, err := id.Key()
+	if err != nil {
+		return nil, err
+	}
+	promotions := make([]Promotion, 0)
+	err = rs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(promotionsBucket)
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get(key)
+		if v == nil {
+			return nil
+		}
+		return json.Unmarshal(v, &promotions)
+	})
+	return promotions, err
+}
+
+// promotionSource returns the stored copy of the artifact ref names to copy to destination, which is the one
+// copy outside destination.
+func promotionSource(session *BoltStorage, ref, destination string) (*Artifact, error) {
+	id, at, err := ParseLocatedRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	copies, err := session.Copies(id, at)
+	if err != nil {
+		return nil, err
+	}
+	sources := make([]Artifact, 0, len(copies))
+	for _, c := range copies {
+		if c.Repository != destination {
+			sources = append(sources, c)
+		}
+	}
+	switch {
+	case len(sources) == 1:
+		return &sources[0], nil
+	case len(copies) == 0:
+		return nil, errNotInCatalog
+	case len(sources) == 0:
+		return nil, fmt.Errorf("already in %s", destination)
+	default:
+		refs := make([]string, 0, len(sources))
+		for _, c := range sources {
+			refs = append(refs, c.LocatedRef())
+		}
+		return nil, fmt.Errorf("%s is in %d repositories, name one of %s", ref, len(sources), strings.Join(refs, ", "))
+	}
+}
+
+// Promote copies the artifacts named by the request from the repositories they were imported from to its
+// Destination on behalf of user, one CopyPackageVersions call per package, and records each artifact's promotion
+// and its copy in Destination. Artifacts the catalog already has a copy of in Destination are only copied when
+// AllowOverwrite is set. Outcomes are in the order of the request's artifacts.
+func Promote(ctx context.Context, domains Domains, session *BoltStorage, user string, request PromotionRequest) ([]PromotionOutcome, error) {
+	if user == "" {
+		return nil, errNoUser
+	}
+	if request.Destination == "" {
+		return nil, fmt.Errorf("promotions need a destination repository")
+	}
+
+	now := time.Now()
+	outcomes := make([]PromotionOutcome, len(request.Artifacts))
+	stored := make([]Artifact, 0, len(request.Artifacts))
+	positions := make([]int, 0, len(request.Artifacts))
+	for i, ref := range request.Artifacts {
+		outcomes[i] = PromotionOutcome{Ref: ref, Promotion: Promotion{Destination: request.Destination, By: user, At: now}}
+		artifact, err := promotionSource(session, ref, request.Destination)
+		if err != nil {
+			outcomes[i].Error = err.Error()
+			continue
+		}
+		outcomes[i].Source = artifact.Repository
+		promoted, err := session.Get(artifact.ArtifactId, Location{DomainName: artifact.DomainName, Account: artifact.Account, Region: artifact.Region, Repository: request.Destination})
+		if err != nil {
+			outcomes[i].Error = err.Error()
+			continue
+		}
+		if promoted != nil && !request.AllowOverwrite {
+			outcomes[i].Error = "already in " + request.Destination
+			continue
+		}
+		stored = append(stored, *artifact)
+		positions = append(positions, i)
+	}
+
+	groups, errs := byPackage(domains, stored)
+	for i, err := range errs {
+		outcomes[positions[i]].Error = err.Error()
+	}
+	for _, group := range groups {
+		response, err := group.wrapper.CopyVersions(ctx, group.repository, request.Destination, group.format, group.namespace, group.pack, group.versions, request.AllowOverwrite, request.IncludeFromUpstream)
+		for _, i := range group.indices {
+			outcome := &outcomes[positions[i]]
+			if err != nil {
+				outcome.Error = err.Error()
+			} else if status, err := versionOutcome(stored[i].Version, response.SuccessfulVersions, response.FailedVersions); err != nil {
+				outcome.Error = err.Error()
+			} else {
+				outcome.Promoted = true
+				copied := stored[i]
+				copied.Repository = request.Destination
+				copied.Status = status
+				if _, err := session.Insert(copied); err != nil {
+					log.Error().Err(err).Str("artifact", outcome.Ref).Msg("Failed to store promoted copy")
+				}
+			}
+			if err := session.RecordPromotion(stored[i].ArtifactId, outcome.Promotion); err != nil {
+				log.Error().Err(err).Str("artifact", outcome.Ref).Msg("Failed to record promotion")
+			}
+		}
+	}
+
+	log.Info().Str("destination", request.Destination).Str("user", user).Interface("outcomes", outcomes).Msg("Promoted artifacts")
+	return outcomes, nil
+}
+
+type PromotionHtmlContext struct {
+	Destination string
+	User        string
+	Outcomes    []PromotionOutcome
+}
+
+// PromotionRoutes serves POST /artifacts/promote, which copies artifacts to another repository on behalf of the
+// user named by the s.UserHeader header, from a JSON PromotionRequest or the listing's form posted from the
+// catalog's own pages, and GET /artifacts/promotions?artifact=namespace:package:version, which returns the
+// promotions recorded for each artifact named.
+func PromotionRoutes(s Specification, domains Domains, session *BoltStorage) Routes {
+	return func(r *mux.Router) {
+		r.Methods("POST").Headers("Content-Type", "application/json").Path("/artifacts/promote").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			body := PromotionRequest{}
+			if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			outcomes, err := Promote(request.Context(), domains, session, request.Header.Get(s.UserHeader), body)
+			if err != nil {
+				http.Error(writer, err.Error(), userStatus(err))
+				return
+			}
+			jsonObjects, err := json.Marshal(outcomes)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			_, _ = writer.Write(jsonObjects)
+		})
+
+		r.Methods("POST").Path("/artifacts/promote").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if !sameSite(request) {
+				http.Error(writer, "Forms must be posted from the catalog", http.StatusForbidden)
+				return
+			}
+			if err := request.ParseForm(); err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			body := PromotionRequest{
+				Destination:         request.PostForm.Get("destination"),
+				Artifacts:           request.PostForm["artifact"],
+				AllowOverwrite:      request.PostForm.Get("allow_overwrite") != "",
+				IncludeFromUpstream: request.PostForm.Get("include_from_upstream") != "",
+			}
+			user := request.Header.Get(s.UserHeader)
+			outcomes, err := Promote(request.Context(), domains, session, user, body)
+			if err != nil {
+				http.Error(writer, err.Error(), userStatus(err))
+				return
+			}
+			renderTemplate(writer, "promotion", PromotionHtmlContext{Destination: body.Destination, User: user, Outcomes: outcomes})
+		})
+
+		r.Methods("GET").Path("/artifacts/promotions").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			promotions := make(map[string][]Promotion)
+			for _, ref := range request.URL.Query()["artifact"] {
+				id, _, err := ParseLocatedRef(ref)
+				if err != nil {
+					http.Error(writer, err.Error(), http.StatusBadRequest)
+					return
+				}
+				promotions[ref], err = session.Promotions(id)
+				if err != nil {
+					http.Error(writer, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
+			jsonObjects, err := json.Marshal(promotions)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			_, _ = writer.Write(jsonObjects)
+		})
+	}
+}
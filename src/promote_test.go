@@ -0,0 +1,124 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPromote(t *testing.T) {
+	server, s := fakeCodeArtifact(t)
+	s.UserHeader = "X-Forwarded-User"
+	storage := newTestStorage(t)
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadArtifacts(context.Background(), importers, s, storage)
+	domains := CodeArtifactDomains(importers)
+
+	refs := []string{"com.acme:widget:1.0.0", "com.acme:widget:1.1.0", "acme:client:3.0.0", "com.acme:widget:9.9.9"}
+	outcomes, err := Promote(context.Background(), domains, storage, "alice", PromotionRequest{Destination: "release", Artifacts: refs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, promoted := range []bool{true, true, false, false} {
+		if outcomes[i].Ref != refs[i] || outcomes[i].Promoted != promoted {
+			t.Errorf("Expected %s promoted to be %v, got %+v", refs[i], promoted, outcomes[i])
+		}
+	}
+	if outcomes[2].Error == "" || outcomes[3].Error == "" {
+		t.Errorf("Expected artifacts already in release or not in the catalog to be refused, got %+v", outcomes)
+	}
+	if v, ok := server.Version("release", "maven", "com.acme", "widget", "1.0.0"); !ok || v.Summary != "Widgets" {
+		t.Errorf("Expected widget 1.0.0 to be copied to release, got %+v", v)
+	}
+	id := ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.0.0"}
+	if copies, _ := storage.Copies(id, Location{}); len(copies) != 2 || copies[0].Repository != "internal" || copies[1].Repository != "release" {
+		t.Errorf("Expected the catalog to keep the copy in release beside the one in internal, got %+v", copies)
+	}
+
+	// copying again is refused unless overwriting is allowed
+	widget := []string{"com.acme:widget:1.0.0"}
+	outcomes, _ = Promote(context.Background(), domains, storage, "bob", PromotionRequest{Destination: "release", Artifacts: widget})
+	if outcomes[0].Promoted || outcomes[0].Source != "internal" || outcomes[0].Error != "already in release" {
+		t.Errorf("Expected the copy to be refused, got %+v", outcomes[0])
+	}
+	if promotions, _ := storage.Promotions(id); len(promotions) != 1 || !promotions[0].Promoted || promotions[0].By != "alice" {
+		t.Errorf("Expected alice's promotion to release to be kept, got %+v", promotions)
+	}
+	outcomes, _ = Promote(context.Background(), domains, storage, "bob", PromotionRequest{Destination: "release", Artifacts: widget, AllowOverwrite: true})
+	if !outcomes[0].Promoted {
+		t.Errorf("Expected the copy to overwrite, got %+v", outcomes[0])
+	}
+	// a copy in another domain's repository of the same name doesn't count
+	other := Artifact{ArtifactId: ArtifactId{Namespace: "com.acme", Package: "gadget", Version: "2.0.0"}, DomainName: "other", Repository: "release", Format: "maven", Status: Published, CreateTime: published}
+	if _, err := storage.Insert(other); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Promote(context.Background(), domains, storage, "", PromotionRequest{Destination: "release", Artifacts: widget}); err != errNoUser {
+		t.Errorf("Expected a promotion without a user to be refused, got %v", err)
+	}
+
+	if _, err := Promote(context.Background(), domains, storage, "bob", PromotionRequest{Artifacts: widget}); err == nil {
+		t.Error("Expected a promotion without a destination to be refused")
+	}
+
+	LoadTemplates(Specification{Templates: "templates/"})
+	router := initRouting(storage, PromotionRoutes(s, domains, storage))
+	body, _ := json.Marshal(PromotionRequest{Destination: "release", Artifacts: []string{"com.acme:gadget:2.0.0"}})
+	request := httptest.NewRequest("POST", "/artifacts/promote", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a promotion without a user to be refused, got %d", response.Code)
+	}
+	request = httptest.NewRequest("POST", "/artifacts/promote", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Forwarded-User", "carol")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	served := make([]PromotionOutcome, 0)
+	if err := json.NewDecoder(response.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 1 || !served[0].Promoted || served[0].Source != "internal" || served[0].By != "carol" {
+		t.Errorf("Expected gadget 2.0.0 to be promoted from internal, got %+v", served)
+	}
+
+	form := url.Values{"destination": {"release"}, "artifact": {"com.acme:widget:1.1.0"}, "allow_overwrite": {"true"}}
+	request = httptest.NewRequest("POST", "/artifacts/promote", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("X-Forwarded-User", "carol")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("Expected a form posted from elsewhere to be refused, got %d", response.Code)
+	}
+	request = httptest.NewRequest("POST", "/artifacts/promote", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("X-Forwarded-User", "carol")
+	request.Header.Set("Referer", "http://example.com/?package=widget")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != 200 || !strings.Contains(response.Body.String(), "com.acme:widget:1.1.0") || !strings.Contains(response.Body.String(), "by carol") {
+		t.Errorf("Expected the outcome page, got %d %s", response.Code, response.Body)
+	}
+
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/artifacts/promotions?artifact=com.acme:gadget:2.0.0", nil))
+	promotions := make(map[string][]Promotion)
+	if err := json.NewDecoder(response.Body).Decode(&promotions); err != nil {
+		t.Fatal(err)
+	}
+	if p := promotions["com.acme:gadget:2.0.0"]; len(p) != 1 || p[0].Destination != "release" || !p[0].Promoted {
+		t.Errorf("Expected gadget's promotion to release, got %+v", promotions)
+	}
+}
@@ -0,0 +1,280 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"mime"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PypiImporter reads a PEP 503 simple repository index, preferring the PEP 691 JSON form when the server
+// offers it. Versions are derived from the release file names, and a version whose files are all yanked
+// (PEP 592) is stored as Unlisted.
+type PypiImporter struct {
+	registry
+	packages []string
+}
+
+func newPypiImporter(c ImporterConfig, s Specification) (Importer, error) {
+	r, err := newRegistry(c, s, "pypi")
+	if err != nil {
+		return nil, err
+	}
+	return &PypiImporter{registry: r, packages: c.Packages}, nil
+}
+
+const pypiJson = "application/vnd.pypi.simple.v1+json"
+
+// pypiAccept asks for the JSON index, falling back to HTML.
+const pypiAccept = pypiJson + ", text/html;q=0.1"
+
+type pypiIndex struct {
+	Projects []struct {
+		Name string `json:"name"`
+	} `json:"projects"`
+}
+
+type pypiProject struct {
+	Name  string     `json:"name"`
+	Files []pypiFile `json:"files"`
+}
+
+type pypiFile struct {
+	Filename   string      `json:"filename"`
+	Yanked     interface{} `json:"yanked"`
+	UploadTime string      `json:"upload-time"`
+}
+
+var (
+	pypiSeparators = regexp.MustCompile(`[-_.]+`)
+	anchorPattern  = regexp.MustCompile(`(?is)<a\s([^>]*)>(.*?)</a>`)
+	yankedPattern  = regexp.MustCompile(`(?i)\sdata-yanked(\s*=|\s|$)`)
+	// versions start with a release number, optionally after an epoch or a v
+	pypiVersionStart = regexp.MustCompile(`^(v|\d+!)?\d`)
+)
+
+// NormalizePypiName applies the PEP 503 name normalization.
+func NormalizePypiName(name string) string {
+	return strings.ToLower(pypiSeparators.ReplaceAllString(name, "-"))
+}
+
+var pypiExtensions = []string{".tar.gz", ".tar.bz2", ".tar.xz", ".tgz", ".zip", ".whl", ".egg"}
+
+// pypiVersion derives the version from a release file name, or returns "" if the file doesn't belong to
+// project.
+func pypiVersion(project, filename string) string {
+	stem := ""
+	extension := ""
+	for _, ext := range pypiExtensions {
+		if strings.HasSuffix(strings.ToLower(filename), ext) {
+			stem, extension = filename[:len(filename)-len(ext)], ext
+			break
+		}
+	}
+	if stem == "" {
+		return ""
+	}
+
+	// sdist names may contain dashes, so try every split until the name matches the project
+	for i := strings.Index(stem, "-"); i >= 0; {
+		version := stem[i+1:]
+		if NormalizePypiName(stem[:i]) == project && pypiVersionStart.MatchString(version) {
+			if extension == ".whl" || extension == ".egg" {
+				// wheel and egg names carry build and platform tags after the version
+				version = strings.SplitN(version, "-", 2)[0]
+			}
+			return version
+		}
+		next := strings.Index(stem[i+1:], "-")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return ""
+}
+
+func pypiYanked(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		// a reason means yanked, even an empty one
+		return true
+	}
+	return false
+}
+
+func (p *PypiImporter) Import(ctx context.Context, report *SyncReport, out chan<- Artifact) {
+	defer close(out)
+	report.count(1, 0, 0)
+
+	var listed []PackageRef
+	projects := p.packages
+	if len(projects) == 0 {
+		var err error
+		projects, err = p.projects(ctx)
+		if err != nil {
+			out <- Artifact{DomainName: p.name, Repository: p.repository, Error: err}
+			return
+		}
+	} else {
+		// only a listing of the whole index covers every project
+		listed = make([]PackageRef, 0, len(projects))
+	}
+
+	filters := p.PackageFilters.For(p.repository)
+	complete := true
+	for _, project := range projects {
+		if report.IsAborted() || ctx.Err() != nil {
+			return
+		}
+		name := NormalizePypiName(project)
+		if !filters.Matches("pypi", "", name) {
+			continue
+		}
+		if listed != nil {
+			listed = append(listed, PackageRef{Package: name})
+		}
+		report.count(0, 1, 0)
+		err := p.importProject(ctx, name, out)
+		if err != nil {
+			out <- Artifact{ArtifactId: ArtifactId{Package: name}, DomainName: p.name, Repository: p.repository, Error: err}
+			complete = false
+		}
+	}
+
+	if complete {
+		report.Complete(Scope{DomainName: p.name, Repository: p.repository, Filters: filters, Packages: listed})
+	}
+}
+
+// fetch gets a page of the index, reporting whether the server answered with JSON rather than HTML.
+func (p *PypiImporter) fetch(ctx context.Context, ref string) ([]byte, bool, error) {
+	u, err := p.resolve(ref)
+	if err != nil {
+		return nil, false, err
+	}
+	body, header, err := p.get(ctx, u.String(), pypiAccept)
+	if err != nil {
+		return nil, false, err
+	}
+	contentType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return body, contentType == pypiJson, nil
+}
+
+// projects lists every project in the index.
+func (p *PypiImporter) projects(ctx context.Context) ([]string, error) {
+	body, isJson, err := p.fetch(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	if isJson {
+		index := pypiIndex{}
+		err = json.Unmarshal(body, &index)
+		if err != nil {
+			return nil, fmt.Errorf("parsing index: %w", err)
+		}
+		for _, project := range index.Projects {
+			names = append(names, project.Name)
+		}
+		return names, nil
+	}
+
+	for _, match := range anchorPattern.FindAllStringSubmatch(string(body), -1) {
+		names = append(names, html.UnescapeString(strings.TrimSpace(match[2])))
+	}
+	return names, nil
+}
+
+// files lists the release files of a project.
+func (p *PypiImporter) files(ctx context.Context, name string) ([]pypiFile, error) {
+	body, isJson, err := p.fetch(ctx, name+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	if isJson {
+		project := pypiProject{}
+		err = json.Unmarshal(body, &project)
+		if err != nil {
+			return nil, fmt.Errorf("parsing project %s: %w", name, err)
+		}
+		return project.Files, nil
+	}
+
+	files := make([]pypiFile, 0)
+	for _, match := range anchorPattern.FindAllStringSubmatch(string(body), -1) {
+		files = append(files, pypiFile{
+			Filename: html.UnescapeString(strings.TrimSpace(match[2])),
+			Yanked:   yankedPattern.MatchString(" " + match[1]),
+		})
+	}
+	return files, nil
+}
+
+func (p *PypiImporter) importProject(ctx context.Context, name string, out chan<- Artifact) error {
+	files, err := p.files(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	type release struct {
+		yanked   bool
+		uploaded time.Time
+	}
+	releases := make(map[string]*release)
+	for _, f := range files {
+		version := pypiVersion(name, f.Filename)
+		if version == "" {
+			continue
+		}
+		r, ok := releases[version]
+		if !ok {
+			r = &release{yanked: true}
+			releases[version] = r
+		}
+		// a release is only yanked once every file in it is
+		r.yanked = r.yanked && pypiYanked(f.Yanked)
+		uploaded, err := time.Parse(time.RFC3339, f.UploadTime)
+		if err == nil && (r.uploaded.IsZero() || uploaded.Before(r.uploaded)) {
+			r.uploaded = uploaded
+		}
+	}
+
+	versions := make([]string, 0, len(releases))
+	for v := range releases {
+		versions = append(versions, v)
+	}
+	sort.Strings(versions)
+	for _, v := range versions {
+		r := releases[v]
+		status := Published
+		if r.yanked {
+			status = Unlisted
+		}
+		created := r.uploaded
+		if created.IsZero() {
+			created = time.Now()
+		}
+		out <- Artifact{
+			ArtifactId: ArtifactId{
+				Package: name,
+				Version: v,
+			},
+			Repository: p.repository,
+			DomainName: p.name,
+			Format:     "pypi",
+			Status:     status,
+			CreateTime: created,
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package artifacts
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPypiVersion(t *testing.T) {
+	for filename, version := range map[string]string{
+		"requests-2.26.0.tar.gz":                   "2.26.0",
+		"requests-2.26.0-py2.py3-none-any.whl":     "2.26.0",
+		"Acme.Widgets-1.0.zip":                     "1.0",
+		"acme_widgets-1.0rc1-py3-none-any.whl":     "1.0rc1",
+		"acme-widgets-legacy-1.0.tar.gz":           "",
+		"acme_widgets-0.9-py3.8.egg":               "0.9",
+		"acme_widgets-0.9.tar.gz.asc":              "",
+		"unrelated-1.0.tar.gz":                     "",
+		"acme-widgets-1.0-cp39-cp39-linux_x86.whl": "1.0",
+	} {
+		if got := pypiVersion("acme-widgets", strings.Replace(filename, "requests", "acme-widgets", 1)); got != version {
+			t.Errorf("pypiVersion(%q) = %q, want %q", filename, got, version)
+		}
+	}
+}
+
+func TestPypiImporter(t *testing.T) {
+	html := map[string]string{
+		"/simple/": `<!DOCTYPE html><html><body><a href="/simple/acme-widgets/">Acme.Widgets</a></body></html>`,
+		"/simple/acme-widgets/": `<!DOCTYPE html><html><body>
+			<a href="../../files/acme_widgets-1.0-py3-none-any.whl#sha256=aa">acme_widgets-1.0-py3-none-any.whl</a>
+			<a href="../../files/acme-widgets-1.0.tar.gz#sha256=bb">acme-widgets-1.0.tar.gz</a>
+			<a href="../../files/acme-widgets-1.1.tar.gz#sha256=cc" data-yanked="broken">acme-widgets-1.1.tar.gz</a>
+		</body></html>`,
+	}
+	json := map[string]string{
+		"/simple/": `{"meta": {"api-version": "1.0"}, "projects": [{"name": "Acme.Widgets"}]}`,
+		"/simple/acme-widgets/": `{"meta": {"api-version": "1.0"}, "name": "acme-widgets", "files": [
+			{"filename": "acme_widgets-1.0-py3-none-any.whl", "url": "x", "hashes": {}, "upload-time": "2021-03-01T00:00:00Z"},
+			{"filename": "acme-widgets-1.0.tar.gz", "url": "x", "hashes": {}, "yanked": false, "upload-time": "2021-02-01T00:00:00Z"},
+			{"filename": "acme-widgets-1.1.tar.gz", "url": "x", "hashes": {}, "yanked": "broken"}
+		]}`,
+	}
+
+	for name, serveJson := range map[string]bool{"html": false, "json": true} {
+		t.Run(name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				pages := html
+				w.Header().Set("Content-Type", "text/html")
+				if serveJson && strings.Contains(r.Header.Get("Accept"), pypiJson) {
+					pages = json
+					w.Header().Set("Content-Type", pypiJson)
+				}
+				body, ok := pages[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				_, _ = w.Write([]byte(body))
+			}))
+			defer server.Close()
+
+			storage := newTestStorage(t)
+			s := Specification{
+				PageSize:  10,
+				Importers: ImporterConfigs{{Type: "pypi", Url: server.URL + "/simple/", Repository: "pypi-private"}},
+			}
+			importers, err := NewImporters(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := LoadArtifacts(context.Background(), importers, s, storage)
+			if len(report.Failures) > 0 {
+				t.Fatalf("Unexpected failures %+v", report.Failures)
+			}
+
+			list, err := storage.List(AllStatuses, "", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			statuses := make(map[string]Status)
+			for _, a := range list {
+				if a.Package != "acme-widgets" || a.Format != "pypi" || a.Namespace != "" {
+					t.Errorf("Unexpected artifact %+v", a)
+				}
+				statuses[a.Version] = a.Status
+				if serveJson && a.Version == "1.0" && a.CreateTime.Month() != 2 {
+					t.Errorf("Expected the earliest upload time, got %v", a.CreateTime)
+				}
+			}
+			if len(statuses) != 2 || statuses["1.0"] != Published || statuses["1.1"] != Unlisted {
+				t.Errorf("Expected 1.0 published and 1.1 yanked, got %+v", statuses)
+			}
+		})
+	}
+}
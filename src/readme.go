@@ -0,0 +1,278 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	bolt "go.etcd.io/bbolt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+var readmesBucket = []byte("readmes")
+
+// readmeFormats are the formats CodeArtifact extracts READMEs from.
+var readmeFormats = map[string]bool{"npm": true, "pypi": true}
+
+// Readme is the README of a package, as published with Version.
+type Readme struct {
+	Version   string
+	Markdown  string
+	FetchedAt time.Time
+}
+
+// storedReadmeKey is what a README is stored under: a package of one format in one repository. Same-named packages
+// of other formats, or in other repositories, have READMEs of their own.
+type storedReadmeKey struct {
+	Format    string
+	Namespace string
+	Package   string
+	Location  storedLocation
+}
+
+// readmeKey identifies the package of version a, whichever version its README came from.
+func readmeKey(a Artifact) ([]byte, error) {
+	return asn1.Marshal(storedReadmeKey{
+		Format:    a.Format,
+		Namespace: a.Namespace,
+		Package:   a.Package,
+		Location:  storedLocation{DomainName: a.DomainName, Account: a.Account, Repository: a.Repository, Region: a.Region},
+	})
+}
+
+// Readme returns the stored README of the package of version a, or nil if none is stored.
+func (rs *BoltStorage) Readme(a Artifact) (*Readme, error) {
+	key, err := readmeKey(a)
+	if err != nil {
+		return nil, err
+	}
+	var readme *Readme
+	err = rs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(readmesBucket)
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get(key)
+		if v == nil {
+			return nil
+		}
+		readme = &Readme{}
+		return json.Unmarshal(v, readme)
+	})
+	return readme, err
+}
+
+// SaveReadme replaces the stored README of the package of version a.
+func (rs *BoltStorage) SaveReadme(a Artifact, readme Readme) error {
+	key, err := readmeKey(a)
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(readme)
+	if err != nil {
+		return err
+	}
+	return rs.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(readmesBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, value)
+	})
+}
+
+// packageVersionsIn returns the stored versions of exactly the package, newest first.
+func packageVersionsIn(session *BoltStorage, namespace, pack string) ([]Artifact, error) {
+	candidates, err := session.List(AllStatuses, namespace, pack)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]Artifact, 0, len(candidates))
+	for _, a := range candidates {
+		if a.Namespace == namespace && a.Package == pack {
+			versions = append(versions, a)
+		}
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		if !versions[i].CreateTime.Equal(versions[j].CreateTime) {
+			return versions[i].CreateTime.After(versions[j].CreateTime)
+		}
+		return versions[i].Version > versions[j].Version
+	})
+	return versions, nil
+}
+
+// latestVersion is the newest published version, or the newest of any status when none is published.
+func latestVersion(newestFirst []Artifact) *Artifact {
+	for i := range newestFirst {
+		if newestFirst[i].Status == Published {
+			return &newestFirst[i]
+		}
+	}
+	if len(newestFirst) == 0 {
+		return nil
+	}
+	return &newestFirst[0]
+}
+
+// PackageReadme returns the README of the latest version of a package, fetching it from CodeArtifact unless it
+// is already stored for that version. It is nil for formats without READMEs. A stored README of an older version
+// is returned along with the error when fetching fails.
+func PackageReadme(ctx context.Context, domains Domains, session *BoltStorage, latest *Artifact) (*Readme, error) {
+	if latest == nil || !readmeFormats[latest.Format] {
+		return nil, nil
+	}
+	stored, err := session.Readme(*latest)
+	if err != nil {
+		return nil, err
+	}
+	if stored != nil && stored.Version == latest.Version {
+		return stored, nil
+	}
+
+	wrapper, err := domains.For(*latest)
+	if err != nil {
+		return stored, err
+	}
+	markdown, err := wrapper.Readme(ctx, latest.Repository, latest.Format, latest.Namespace, latest.Package, latest.Version)
+	if err != nil {
+		return stored, err
+	}
+	readme := Readme{Version: latest.Version, Markdown: markdown, FetchedAt: time.Now()}
+	if err := session.SaveReadme(*latest, readme); err != nil {
+		log.Error().Err(err).Str("artifact", latest.Ref()).Msg("Failed to store README")
+	}
+	return &readme, nil
+}
+
+// markdown renders READMEs as GitHub does, except that raw HTML and dangerous links are left out, since
+// READMEs are written by whoever publishes a package.
+var markdown = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+func renderReadme(readme *Readme) (template.HTML, error) {
+	if readme == nil || readme.Markdown == "" {
+		return "", nil
+	}
+	var out bytes.Buffer
+	if err := markdown.Convert([]byte(readme.Markdown), &out); err != nil {
+		return "", err
+	}
+	return template.HTML(out.String()), nil
+}
+
+// PackageDetail is a package's stored versions, newest first, and the README of the latest.
+type PackageDetail struct {
+	Namespace   string
+	Package     string
+	Versions    []Artifact
+	Readme      *Readme `json:",omitempty"`
+	ReadmeError string  `json:",omitempty"`
+}
+
+type PackageHtmlContext struct {
+	PackageDetail
+	ReadmeHtml template.HTML
+}
+
+// PackagePath is the path of the page of the artifact's package. The namespace and package are escaped, since Go
+// module paths and OCI repository names hold slashes, and a blank namespace is written "-".
+func (a Artifact) PackagePath() string {
+	namespace := a.Namespace
+	if namespace == "" {
+		namespace = "-"
+	}
+	return "/packages/" + url.PathEscape(namespace) + "/" + url.PathEscape(a.Package)
+}
+
+// packageName reads the namespace and package from a path written by PackagePath. The escaped path is read, since
+// the unescaped one can't tell the slashes in a namespace from the one after it.
+func packageName(request *http.Request) (string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(request.URL.EscapedPath(), "/packages/"), "/", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("%s doesn't name a package", request.URL.Path)
+	}
+	namespace, err := url.PathUnescape(parts[0])
+	if err != nil {
+		return "", "", err
+	}
+	pack, err := url.PathUnescape(parts[1])
+	if err != nil {
+		return "", "", err
+	}
+	if namespace == "-" {
+		namespace = ""
+	}
+	return namespace, pack, nil
+}
+
+func packageDetail(domains Domains, session *BoltStorage, request *http.Request) (*PackageDetail, error) {
+	namespace, pack, err := packageName(request)
+	if err != nil {
+		return nil, err
+	}
+	detail := &PackageDetail{Namespace: namespace, Package: pack}
+	versions, err := packageVersionsIn(session, detail.Namespace, detail.Package)
+	if err != nil {
+		return nil, err
+	}
+	detail.Versions = versions
+	detail.Readme, err = PackageReadme(request.Context(), domains, session, latestVersion(versions))
+	if err != nil {
+		log.Error().Err(err).Str("namespace", detail.Namespace).Str("package", detail.Package).Msg("Failed to fetch README")
+		detail.ReadmeError = err.Error()
+	}
+	return detail, nil
+}
+
+// PackageRoutes serves GET /packages/{namespace}/{package}, a package's versions and the README of the latest,
+// as JSON when requested with Content-Type: application/json and as a page otherwise. Packages without a
+// namespace are asked for with "-", and slashes in either part are escaped, as PackagePath writes them.
+func PackageRoutes(domains Domains, session *BoltStorage) Routes {
+	return func(r *mux.Router) {
+		r.Methods("GET").Headers("Content-Type", "application/json").Path("/packages/{namespace}/{package:.+}").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			detail, err := packageDetail(domains, session, request)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if len(detail.Versions) == 0 {
+				http.Error(writer, "no such package", http.StatusNotFound)
+				return
+			}
+			jsonObjects, err := json.Marshal(detail)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			_, _ = writer.Write(jsonObjects)
+		})
+
+		r.Methods("GET").Path("/packages/{namespace}/{package:.+}").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			detail, err := packageDetail(domains, session, request)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if len(detail.Versions) == 0 {
+				http.Error(writer, "no such package", http.StatusNotFound)
+				return
+			}
+			html, err := renderReadme(detail.Readme)
+			if err != nil {
+				detail.ReadmeError = err.Error()
+			}
+			renderTemplate(writer, "package", PackageHtmlContext{PackageDetail: *detail, ReadmeHtml: html})
+		})
+	}
+}
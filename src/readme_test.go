@@ -0,0 +1,106 @@
+package artifacts
+
+import (
+	"artifacts/src/codeartifacttest"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPackageRoutes(t *testing.T) {
+	server, s := fakeCodeArtifact(t)
+	server.AddPackage("release", codeartifacttest.Package{Format: "npm", Namespace: "acme", Name: "docs", Versions: []codeartifacttest.Version{
+		{Version: "1.0.0", Status: "Published", Published: published, Readme: "# Old docs"},
+		{Version: "2.0.0", Status: "Published", Published: published.Add(time.Hour), Readme: "# Docs\n\n<script>alert(1)</script>\n\n[click](javascript:alert(1))\n\n| a | b |\n|---|---|\n| 1 | 2 |\n"},
+	}})
+	storage := newTestStorage(t)
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadArtifacts(context.Background(), importers, s, storage)
+	LoadTemplates(Specification{Templates: "templates/"})
+	router := initRouting(storage, PackageRoutes(CodeArtifactDomains(importers), storage))
+	get := func(path string, asJson bool) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", path, nil)
+		if asJson {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+
+	detail := PackageDetail{}
+	if err := json.NewDecoder(get("/packages/acme/docs", true).Body).Decode(&detail); err != nil {
+		t.Fatal(err)
+	}
+	if len(detail.Versions) != 2 || detail.Versions[0].Version != "2.0.0" || detail.Readme == nil || detail.Readme.Version != "2.0.0" {
+		t.Errorf("Expected both versions and the README of 2.0.0, got %+v", detail)
+	}
+
+	page := get("/packages/acme/docs", false).Body.String()
+	for _, expected := range []string{"<h1>Docs</h1>", "<table>", "README of 2.0.0"} {
+		if !strings.Contains(page, expected) {
+			t.Errorf("Expected the page to contain %s, got %s", expected, page)
+		}
+	}
+	for _, unexpected := range []string{"<script>", "javascript:"} {
+		if strings.Contains(page, unexpected) {
+			t.Errorf("Expected the README to be sanitized of %s, got %s", unexpected, page)
+		}
+	}
+
+	server.RemoveRepository("release")
+	if page := get("/packages/acme/docs", false).Body.String(); !strings.Contains(page, "<h1>Docs</h1>") || strings.Contains(page, "Couldn&#39;t fetch") {
+		t.Errorf("Expected the stored README, got %s", page)
+	}
+
+	if response := get("/packages/com.acme/widget", false); response.Code != 200 || strings.Contains(response.Body.String(), "README") {
+		t.Errorf("Expected a maven package's page without a README, got %d %s", response.Code, response.Body)
+	}
+	if response := get("/packages/-/missing", false); response.Code != 404 {
+		t.Errorf("Expected an unknown package not to be found, got %d", response.Code)
+	}
+	if listing := get("/", false).Body.String(); !strings.Contains(listing, `href="/packages/com.acme/widget"`) {
+		t.Errorf("Expected the listing to link to package pages, got %s", listing)
+	}
+
+	module := Artifact{ArtifactId: ArtifactId{Namespace: "github.com/acme", Package: "lib/v2", Version: "v2.0.0"}, DomainName: "proxy.golang.org", Repository: "proxy.golang.org", Format: "go", Status: Published, CreateTime: published}
+	if _, err := storage.Insert(module); err != nil {
+		t.Fatal(err)
+	}
+	if listing := get("/", false).Body.String(); !strings.Contains(listing, `href="/packages/github.com%2Facme/lib%2Fv2"`) {
+		t.Errorf("Expected the link to a Go module to escape its path, got %s", listing)
+	}
+	detail = PackageDetail{}
+	if err := json.NewDecoder(get(module.PackagePath(), true).Body).Decode(&detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.Namespace != "github.com/acme" || detail.Package != "lib/v2" || len(detail.Versions) != 1 {
+		t.Errorf("Expected the Go module's page, got %+v", detail)
+	}
+}
+
+func TestReadmesAreKeptPerRepository(t *testing.T) {
+	storage := newTestStorage(t)
+	internal := Artifact{ArtifactId: ArtifactId{Namespace: "acme", Package: "docs", Version: "1.0.0"}, DomainName: "acme", Repository: "internal", Format: "npm"}
+	release := internal
+	release.Repository = "release"
+	pypi := internal
+	pypi.Format = "pypi"
+	for i, a := range []Artifact{internal, release, pypi} {
+		if err := storage.SaveReadme(a, Readme{Version: a.Version, Markdown: fmt.Sprintf("# README %d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, a := range []Artifact{internal, release, pypi} {
+		if readme, err := storage.Readme(a); err != nil || readme == nil || readme.Markdown != fmt.Sprintf("# README %d", i) {
+			t.Errorf("Expected README %d for %s in %s, got %+v %v", i, a.Format, a.Repository, readme, err)
+		}
+	}
+}
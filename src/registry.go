@@ -0,0 +1,142 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// registry is the plumbing shared by importers that read a package registry over HTTP.
+type registry struct {
+	Specification
+	name       string
+	repository string
+	base       *url.URL
+	client     *http.Client
+	username   string
+	password   string
+	token      string
+}
+
+// HTTPStatusError is returned for responses outside 2xx.
+type HTTPStatusError struct {
+	Url        string
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("GET %s: %d %s", e.Url, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func newRegistry(c ImporterConfig, s Specification, kind string) (registry, error) {
+	if c.Url == "" {
+		return registry{}, fmt.Errorf("%s importer needs a Url", kind)
+	}
+	base, err := url.Parse(c.Url)
+	if err != nil {
+		return registry{}, err
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	name := c.Name
+	if name == "" {
+		name = kind
+	}
+	repository := c.Repository
+	if repository == "" {
+		repository = base.Host + strings.TrimSuffix(base.Path, "/")
+	}
+
+	return registry{
+		Specification: s,
+		name:          name,
+		repository:    repository,
+		base:          base,
+		client:        &http.Client{Timeout: 30 * time.Second},
+		username:      c.Username,
+		password:      c.Password,
+		token:         c.Token,
+	}, nil
+}
+
+func (r *registry) Name() string {
+	return r.name
+}
+
+// resolve turns a reference relative to the registry's base url into an absolute url.
+func (r *registry) resolve(ref string) (*url.URL, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	return r.base.ResolveReference(u), nil
+}
+
+func (r *registry) request(ctx context.Context, method, u, accept string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, u, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	if r.token != "" {
+		request.Header.Set("Authorization", "Bearer "+r.token)
+	} else if r.username != "" {
+		request.SetBasicAuth(r.username, r.password)
+	}
+	return r.client.Do(request)
+}
+
+// get fetches u, retrying server errors. Client errors are returned straight away as an *HTTPStatusError.
+func (r *registry) get(ctx context.Context, u, accept string) ([]byte, http.Header, error) {
+	var body []byte
+	var header http.Header
+	var clientErr error
+	err := retry(ctx, r.Retries, r.RetryBackoff, func() error {
+		response, err := r.request(ctx, http.MethodGet, u, accept)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode >= 400 && response.StatusCode < 500 {
+			clientErr = &HTTPStatusError{Url: u, StatusCode: response.StatusCode}
+			return nil
+		}
+		if response.StatusCode >= 300 {
+			return &HTTPStatusError{Url: u, StatusCode: response.StatusCode}
+		}
+
+		body, err = io.ReadAll(response.Body)
+		header = response.Header
+		return err
+	})
+	if clientErr != nil {
+		return nil, nil, clientErr
+	}
+	return body, header, err
+}
+
+// lastModified returns the Last-Modified time the registry reports for u, or the zero time.
+func (r *registry) lastModified(ctx context.Context, u string) time.Time {
+	response, err := r.request(ctx, http.MethodHead, u, "")
+	if err != nil {
+		return time.Time{}
+	}
+	_ = response.Body.Close()
+	if response.StatusCode >= 300 {
+		return time.Time{}
+	}
+	t, err := http.ParseTime(response.Header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
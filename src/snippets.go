@@ -0,0 +1,212 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact/types"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var errUnknownClient = errors.New("no configuration for that client")
+
+// clientSnippet is the configuration a package manager needs to use a CodeArtifact repository of Format.
+type clientSnippet struct {
+	Format   string
+	Template *template.Template
+}
+
+var snippetFuncs = template.FuncMap{
+	// host strips the scheme, for clients that key credentials by host and path
+	"host": func(endpoint string) string {
+		return strings.TrimPrefix(strings.TrimPrefix(endpoint, "https://"), "http://")
+	},
+	"slash": func(endpoint string) string {
+		return strings.TrimSuffix(endpoint, "/") + "/"
+	},
+}
+
+func snippet(name, format, text string) clientSnippet {
+	return clientSnippet{Format: format, Template: template.Must(template.New(name).Funcs(snippetFuncs).Parse(text))}
+}
+
+// clientSnippets are keyed by the client they configure.
+var clientSnippets = map[string]clientSnippet{
+	"maven": snippet("settings.xml", "maven", `<!-- ~/.m2/settings.xml: the token for {{ .Domain }} expires at {{ .Expiration }} -->
+<settings>
+  <servers>
+    <server>
+      <id>{{ .Domain }}-{{ .Repository }}</id>
+      <username>aws</username>
+      <password>{{ .Token }}</password>
+    </server>
+  </servers>
+  <profiles>
+    <profile>
+      <id>{{ .Domain }}-{{ .Repository }}</id>
+      <activation>
+        <activeByDefault>true</activeByDefault>
+      </activation>
+      <repositories>
+        <repository>
+          <id>{{ .Domain }}-{{ .Repository }}</id>
+          <url>{{ .Endpoint }}</url>
+        </repository>
+      </repositories>
+    </profile>
+  </profiles>
+</settings>
+`),
+	"gradle": snippet("build.gradle", "maven", `// build.gradle: the token for {{ .Domain }} expires at {{ .Expiration }}
+repositories {
+    maven {
+        url '{{ .Endpoint }}'
+        credentials {
+            username 'aws'
+            password '{{ .Token }}'
+        }
+    }
+}
+`),
+	"npm": snippet(".npmrc", "npm", `# .npmrc: the token for {{ .Domain }} expires at {{ .Expiration }}
+registry={{ .Endpoint }}
+//{{ host .Endpoint }}:_authToken={{ .Token }}
+//{{ host .Endpoint }}:always-auth=true
+`),
+	"pip": snippet("pip.conf", "pypi", `# pip.conf: the token for {{ .Domain }} expires at {{ .Expiration }}
+[global]
+index-url = https://aws:{{ .Token }}@{{ host .Endpoint | slash }}simple/
+`),
+	"nuget": snippet("nuget.config", "nuget", `<?xml version="1.0" encoding="utf-8"?>
+<!-- nuget.config: the token for {{ .Domain }} expires at {{ .Expiration }} -->
+<configuration>
+  <packageSources>
+    <add key="{{ .Domain }}-{{ .Repository }}" value="{{ slash .Endpoint }}v3/index.json" />
+  </packageSources>
+  <packageSourceCredentials>
+    <{{ .Domain }}-{{ .Repository }}>
+      <add key="Username" value="aws" />
+      <add key="ClearTextPassword" value="{{ .Token }}" />
+    </{{ .Domain }}-{{ .Repository }}>
+  </packageSourceCredentials>
+</configuration>
+`),
+}
+
+// ClientConfig is configuration for a package manager to use a repository, with a token that lasts until
+// Expiration.
+type ClientConfig struct {
+	Client     string
+	Format     string
+	Domain     string
+	Repository string
+	Endpoint   string
+	Expiration time.Time
+	Snippet    string
+	// Token is only shown through Snippet
+	Token string `json:"-"`
+}
+
+// NewClientConfig renders the configuration client needs to use repository in domain, with a token that lasts
+// duration.
+func NewClientConfig(ctx context.Context, domains Domains, domain, repository, client string, duration time.Duration) (*ClientConfig, error) {
+	snippet, ok := clientSnippets[client]
+	if !ok {
+		return nil, errUnknownClient
+	}
+	wrapper, err := domains.Named(domain)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := wrapper.RepositoryEndpoint(ctx, repository, snippet.Format)
+	if err != nil {
+		return nil, err
+	}
+	token, expiration, err := wrapper.AuthorizationToken(ctx, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ClientConfig{
+		Client:     client,
+		Format:     snippet.Format,
+		Domain:     domain,
+		Repository: repository,
+		Endpoint:   endpoint,
+		Expiration: expiration,
+		Token:      token,
+	}
+	var out bytes.Buffer
+	if err := snippet.Template.Execute(&out, config); err != nil {
+		return nil, err
+	}
+	config.Snippet = out.String()
+	return config, nil
+}
+
+func configErrorStatus(err error) int {
+	var notFound *types.ResourceNotFoundException
+	switch {
+	case errors.Is(err, errUnknownClient), errors.Is(err, ErrUnknownDomain):
+		return http.StatusNotFound
+	case errors.As(err, &notFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// ConfigRoutes serves GET /repositories/{domain}/{repository}/config/{client}, which generates configuration
+// for maven, gradle, npm, pip or nuget to use a repository. The snippet is plain text, or part of a JSON
+// ClientConfig when requested with Content-Type: application/json. Tokens are only handed to users identified by
+// s.UserHeader, which must be set by a trusted proxy, and are requested with the target's TokenRoleArn when it
+// has one.
+func ConfigRoutes(s Specification, domains Domains) Routes {
+	return func(r *mux.Router) {
+		clientConfig := func(writer http.ResponseWriter, request *http.Request) *ClientConfig {
+			user := request.Header.Get(s.UserHeader)
+			if user == "" {
+				http.Error(writer, errNoUser.Error(), http.StatusUnauthorized)
+				return nil
+			}
+			vars := mux.Vars(request)
+			config, err := NewClientConfig(request.Context(), domains, vars["domain"], vars["repository"], vars["client"], s.TokenDuration)
+			if err != nil {
+				http.Error(writer, err.Error(), configErrorStatus(err))
+				return nil
+			}
+			log.Info().Str("user", user).Str("domain", config.Domain).Str("repository", config.Repository).Str("client", config.Client).Time("expiration", config.Expiration).Msg("Issued client configuration")
+			writer.Header().Set("Cache-Control", "no-store")
+			return config
+		}
+
+		r.Methods("GET").Headers("Content-Type", "application/json").Path("/repositories/{domain}/{repository}/config/{client}").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			config := clientConfig(writer, request)
+			if config == nil {
+				return
+			}
+			jsonObjects, err := json.Marshal(config)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			_, _ = writer.Write(jsonObjects)
+		})
+
+		r.Methods("GET").Path("/repositories/{domain}/{repository}/config/{client}").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			config := clientConfig(writer, request)
+			if config == nil {
+				return
+			}
+			writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = writer.Write([]byte(config.Snippet))
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact/types"
+	"github.com/rs/zerolog/log"
+	"strings"
+)
+
+// Domains are the CodeArtifact domains the catalog imports, where changes made from the catalog are sent.
+type Domains []*CodeArtifactWrapper
+
+// CodeArtifactDomains picks the CodeArtifact domains out of importers.
+func CodeArtifactDomains(importers []Importer) Domains {
+	domains := make(Domains, 0)
+	for _, importer := range importers {
+		if wrapper, ok := importer.(*CodeArtifactWrapper); ok {
+			domains = append(domains, wrapper)
+		}
+	}
+	return domains
+}
+
+// For returns the domain the artifact was imported from.
+func (d Domains) For(a Artifact) (*CodeArtifactWrapper, error) {
+	for _, wrapper := range d {
+		if wrapper.Target.Domain != a.DomainName {
+			continue
+		}
+		if wrapper.Target.DomainOwner != "" && a.Account != "" && wrapper.Target.DomainOwner != a.Account {
+			continue
+		}
+		if a.Region != "" && wrapper.Target.Region != a.Region {
+			continue
+		}
+		return wrapper, nil
+	}
+	return nil, fmt.Errorf("%s wasn't imported from a configured CodeArtifact domain", a.Ref())
+}
+
+var ErrUnknownDomain = errors.New("not a configured CodeArtifact domain")
+
+// Named returns the first configured domain called domain.
+func (d Domains) Named(domain string) (*CodeArtifactWrapper, error) {
+	for _, wrapper := range d {
+		if wrapper.Target.Domain == domain {
+			return wrapper, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", domain, ErrUnknownDomain)
+}
+
+var errNotInCatalog = errors.New("not in the catalog")
+
+// locateRef returns the stored copy of the artifact ref names. A plain ref only names a version stored in a
+// single repository; others need the location LocatedRef writes.
+func locateRef(session *BoltStorage, ref string) (*Artifact, error) {
+	id, at, err := ParseLocatedRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	copies, err := session.Copies(id, at)
+	if err != nil {
+		return nil, err
+	}
+	switch len(copies) {
+	case 0:
+		return nil, errNotInCatalog
+	case 1:
+		return &copies[0], nil
+	default:
+		refs := make([]string, 0, len(copies))
+		for _, c := range copies {
+			refs = append(refs, c.LocatedRef())
+		}
+		return nil, fmt.Errorf("%s is in %d repositories, name one of %s", ref, len(copies), strings.Join(refs, ", "))
+	}
+}
+
+// StatusOutcome is what became of one artifact asked to change status.
+type StatusOutcome struct {
+	Ref        string
+	Repository string `json:",omitempty"`
+	Status     Status `json:",omitempty"`
+	Updated    bool
+	Error      string `json:",omitempty"`
+}
+
+// UpdatableStatuses are the statuses CodeArtifact lets a version be moved to directly.
+var UpdatableStatuses = []Status{Published, Unlisted, Archived}
+
+// packageVersions are versions of one package in one repository, which CodeArtifact's bulk operations take
+// together. Indices locate each version in the caller's list.
+type packageVersions struct {
+	wrapper                             *CodeArtifactWrapper
+	repository, format, namespace, pack string
+	versions                            []string
+	indices                             []int
+}
+
+// byPackage groups artifacts by package, in the order packages are first seen. Artifacts from domains that
+// aren't configured are left out and returned as errors by index.
+func byPackage(domains Domains, artifacts []Artifact) ([]*packageVersions, map[int]error) {
+	type key struct {
+		wrapper                             *CodeArtifactWrapper
+		repository, format, namespace, pack string
+	}
+	groups := make([]*packageVersions, 0)
+	found := make(map[key]*packageVersions)
+	errs := make(map[int]error)
+	for i, a := range artifacts {
+		wrapper, err := domains.For(a)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		k := key{wrapper, a.Repository, a.Format, a.Namespace, a.Package}
+		group, ok := found[k]
+		if !ok {
+			group = &packageVersions{wrapper: wrapper, repository: a.Repository, format: a.Format, namespace: a.Namespace, pack: a.Package}
+			found[k] = group
+			groups = append(groups, group)
+		}
+		group.versions = append(group.versions, a.Version)
+		group.indices = append(group.indices, i)
+	}
+	return groups, errs
+}
+
+// versionOutcome picks one version out of the response to a bulk operation, returning the status CodeArtifact
+// confirmed for it or why it failed.
+func versionOutcome(version string, successful map[string]types.SuccessfulPackageVersionInfo, failed map[string]types.PackageVersionError) (Status, error) {
+	if failure, ok := failed[version]; ok {
+		return "", fmt.Errorf("%s: %s", failure.ErrorCode, aws.ToString(failure.ErrorMessage))
+	}
+	success, ok := successful[version]
+	if !ok {
+		return "", errors.New("CodeArtifact didn't report on this version")
+	}
+	return Status(success.Status), nil
+}
+
+// UpdateStatus asks CodeArtifact to move the artifacts named by refs to status on behalf of user, one call per
+// package, and records the new status in the catalog for each version CodeArtifact confirmed. Outcomes are in the
+// order of refs.
+func UpdateStatus(ctx context.Context, domains Domains, session *BoltStorage, user string, status Status, refs []string) ([]StatusOutcome, error) {
+	if user == "" {
+		return nil, errNoUser
+	}
+	updatable := false
+	for _, s := range UpdatableStatuses {
+		updatable = updatable || s == status
+	}
+	if !updatable {
+		return nil, fmt.Errorf("versions can't be moved to %q", status)
+	}
+
+	outcomes := make([]StatusOutcome, len(refs))
+	stored := make([]Artifact, 0, len(refs))
+	// position of each stored artifact in refs
+	positions := make([]int, 0, len(refs))
+	for i, ref := range refs {
+		outcomes[i].Ref = ref
+		artifact, err := locateRef(session, ref)
+		if err != nil {
+			outcomes[i].Error = err.Error()
+			continue
+		}
+		outcomes[i].Repository = artifact.Repository
+		stored = append(stored, *artifact)
+		positions = append(positions, i)
+	}
+
+	groups, errs := byPackage(domains, stored)
+	for i, err := range errs {
+		outcomes[positions[i]].Error = err.Error()
+	}
+	for _, group := range groups {
+		response, err := group.wrapper.UpdateStatus(ctx, group.repository, group.format, group.namespace, group.pack, group.versions, status)
+		if err != nil {
+			for _, i := range group.indices {
+				outcomes[positions[i]].Error = err.Error()
+			}
+			continue
+		}
+
+		for _, i := range group.indices {
+			outcome := &outcomes[positions[i]]
+			confirmed, err := versionOutcome(stored[i].Version, response.SuccessfulVersions, response.FailedVersions)
+			if err != nil {
+				outcome.Error = err.Error()
+				continue
+			}
+			if _, err := session.SetStatus(confirmed, stored[i]); err != nil {
+				outcome.Error = fmt.Sprintf("updated in CodeArtifact, but not the catalog: %v", err)
+				continue
+			}
+			outcome.Status = confirmed
+			outcome.Updated = true
+		}
+	}
+
+	log.Info().Str("status", string(status)).Str("user", user).Interface("outcomes", outcomes).Msg("Changed package version statuses")
+	return outcomes, nil
+}
@@ -0,0 +1,131 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestUpdateStatus(t *testing.T) {
+	server, s := fakeCodeArtifact(t)
+	s.Reconcile = false
+	storage := newTestStorage(t)
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	LoadArtifacts(context.Background(), importers, s, storage)
+	// stored, but gone from CodeArtifact
+	if _, err := storage.Insert(staleWidget()); err != nil {
+		t.Fatal(err)
+	}
+
+	domains := CodeArtifactDomains(importers)
+	refs := []string{"com.acme:widget:1.0.0", "com.acme:widget:0.9.0", "com.acme:gadget:2.0.0", "acme:client:9.9.9", "widget"}
+	outcomes, err := UpdateStatus(context.Background(), domains, storage, "alice", Archived, refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, updated := range []bool{true, false, true, false, false} {
+		if outcomes[i].Ref != refs[i] || outcomes[i].Updated != updated {
+			t.Errorf("Expected %s updated to be %v, got %+v", refs[i], updated, outcomes[i])
+		}
+	}
+	if !strings.HasPrefix(outcomes[1].Error, "NOT_FOUND") {
+		t.Errorf("Expected CodeArtifact's refusal to be reported, got %+v", outcomes[1])
+	}
+
+	if v, _ := server.Version("internal", "maven", "com.acme", "widget", "1.0.0"); v.Status != "Archived" {
+		t.Errorf("Expected CodeArtifact to have archived widget 1.0.0, got %+v", v)
+	}
+	for id, status := range map[ArtifactId]Status{
+		{Namespace: "com.acme", Package: "widget", Version: "1.0.0"}: Archived,
+		{Namespace: "com.acme", Package: "gadget", Version: "2.0.0"}: Archived,
+		{Namespace: "com.acme", Package: "widget", Version: "0.9.0"}: Published,
+	} {
+		if a, _ := onlyCopy(storage, id); a == nil || a.Status != status {
+			t.Errorf("Expected %s to be %s in the catalog, got %+v", id.Ref(), status, a)
+		}
+	}
+
+	if _, err := UpdateStatus(context.Background(), domains, storage, "alice", Deleted, refs); err == nil {
+		t.Error("Expected versions not to be deleted by a status change")
+	}
+	if _, err := UpdateStatus(context.Background(), domains, storage, "", Archived, refs); err != errNoUser {
+		t.Errorf("Expected a change without a user to be refused, got %v", err)
+	}
+
+	LoadTemplates(Specification{Templates: "templates/"})
+	router := initRouting(storage, StatusRoutes(Specification{UserHeader: "X-Forwarded-User"}, domains, storage))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(response.Body.String(), `value="com.acme:widget:1.0.0@acme/internal"`) {
+		t.Errorf("Expected the listing to offer widget 1.0.0 for a status change, got %s", response.Body)
+	}
+
+	body, _ := json.Marshal(StatusRequest{Status: Unlisted, Artifacts: []string{"com.acme:widget:1.1.0"}})
+	request := httptest.NewRequest("POST", "/artifacts/status", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a change without a user to be refused, got %d", response.Code)
+	}
+	request = httptest.NewRequest("POST", "/artifacts/status", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Forwarded-User", "alice")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	served := make([]StatusOutcome, 0)
+	if err := json.NewDecoder(response.Body).Decode(&served); err != nil {
+		t.Fatal(err)
+	}
+	if len(served) != 1 || !served[0].Updated || served[0].Status != Unlisted {
+		t.Errorf("Expected widget 1.1.0 to be unlisted, got %+v", served)
+	}
+
+	form := url.Values{"status": {"Published"}, "artifact": {"com.acme:widget:1.1.0"}}
+	post := func(origin string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/artifacts/status", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("X-Forwarded-User", "alice")
+		if origin != "" {
+			request.Header.Set("Origin", origin)
+		}
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, request)
+		return response
+	}
+	for _, origin := range []string{"", "https://evil.example"} {
+		if response := post(origin); response.Code != http.StatusForbidden {
+			t.Errorf("Expected a form posted from %q to be refused, got %d", origin, response.Code)
+		}
+	}
+	response = post("http://example.com")
+	if response.Code != 200 || !strings.Contains(response.Body.String(), "com.acme:widget:1.1.0") || !strings.Contains(response.Body.String(), "by alice") {
+		t.Errorf("Expected the outcome page, got %d %s", response.Code, response.Body)
+	}
+	if a, _ := onlyCopy(storage, ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.1.0"}); a == nil || a.Status != Published {
+		t.Errorf("Expected widget 1.1.0 to be published again, got %+v", a)
+	}
+}
+
+func TestParseArtifactRef(t *testing.T) {
+	for ref, expected := range map[string]ArtifactId{
+		"com.acme:widget:1.0.0": {Namespace: "com.acme", Package: "widget", Version: "1.0.0"},
+		"left-pad:1.3.0":        {Package: "left-pad", Version: "1.3.0"},
+	} {
+		id, err := ParseArtifactRef(ref)
+		if err != nil || id != expected || id.Ref() != ref {
+			t.Errorf("Expected %s to parse to %+v, got %+v %v", ref, expected, id, err)
+		}
+	}
+	if _, err := ParseArtifactRef("widget"); err == nil {
+		t.Error("Expected a ref without a version to be refused")
+	}
+}
@@ -1,9 +1,11 @@
 package artifacts
 
 import (
+	"bytes"
 	asn1 "encoding/asn1"
 	log "github.com/rs/zerolog/log"
 	bolt "go.etcd.io/bbolt"
+	"sort"
 	"strings"
 	"time"
 )
@@ -16,9 +18,20 @@ func (a *Artifact) data() ArtifactData {
 		Format:     a.Format,
 		Status:     a.Status,
 		CreateTime: a.CreateTime,
+
+		DisplayName:          a.DisplayName,
+		Summary:              a.Summary,
+		Licenses:             a.Licenses,
+		HomePage:             a.HomePage,
+		SourceCodeRepository: a.SourceCodeRepository,
+		Account:              a.Account,
+		ExternalConnection:   a.ExternalConnection,
+		Region:               a.Region,
 	}
 }
 
+// ArtifactData is the stored value of an artifact. Fields added after the first release are optional and
+// explicitly tagged so records written by older versions still decode.
 type ArtifactData struct {
 	Repository string
 	Revision   string
@@ -26,6 +39,36 @@ type ArtifactData struct {
 	Format     string
 	Status     Status
 	CreateTime time.Time
+
+	DisplayName          string   `asn1:"optional,explicit,tag:0"`
+	Summary              string   `asn1:"optional,explicit,tag:1"`
+	Licenses             []string `asn1:"optional,explicit,tag:2"`
+	HomePage             string   `asn1:"optional,explicit,tag:3"`
+	SourceCodeRepository string   `asn1:"optional,explicit,tag:4"`
+	Account              string   `asn1:"optional,explicit,tag:5"`
+	ExternalConnection   string   `asn1:"optional,explicit,tag:6"`
+	Region               string   `asn1:"optional,explicit,tag:7"`
+}
+
+func (d *ArtifactData) artifact(id ArtifactId) Artifact {
+	return Artifact{
+		ArtifactId: id,
+		Repository: d.Repository,
+		Revision:   d.Revision,
+		DomainName: d.DomainName,
+		Format:     d.Format,
+		Status:     d.Status,
+		CreateTime: d.CreateTime,
+
+		DisplayName:          d.DisplayName,
+		Summary:              d.Summary,
+		Licenses:             d.Licenses,
+		HomePage:             d.HomePage,
+		SourceCodeRepository: d.SourceCodeRepository,
+		Account:              d.Account,
+		ExternalConnection:   d.ExternalConnection,
+		Region:               d.Region,
+	}
 }
 
 func (a *Artifact) populateProblems() {
@@ -52,6 +95,40 @@ func (a *ArtifactId) Key() ([]byte, error) {
 	return a.Marshal()
 }
 
+// storedLocation follows the id in the key of an artifact record, so the copies of a version are stored next to
+// each other. Region was added later, and is left out of the keys of copies without one.
+type storedLocation struct {
+	DomainName string
+	Account    string
+	Repository string
+	Region     string `asn1:"optional,explicit,tag:0"`
+}
+
+// storageKey is where the artifact's record is kept: its id's key followed by its location.
+func (a *Artifact) storageKey() ([]byte, error) {
+	return locationKey(a.ArtifactId, a.Location())
+}
+
+func locationKey(id ArtifactId, at Location) ([]byte, error) {
+	key, err := id.Key()
+	if err != nil {
+		return nil, err
+	}
+	location, err := asn1.Marshal(storedLocation{DomainName: at.DomainName, Account: at.Account, Repository: at.Repository, Region: at.Region})
+	if err != nil {
+		return nil, err
+	}
+	return append(key, location...), nil
+}
+
+// unmarshalStorageKey reads the id a record is stored under. Keys written before records were kept per location
+// are the id alone.
+func unmarshalStorageKey(k []byte) (ArtifactId, bool, error) {
+	id := ArtifactId{}
+	rest, err := asn1.Unmarshal(k, &id)
+	return id, len(rest) > 0, err
+}
+
 type BoltStorage struct {
 	db *bolt.DB
 }
@@ -66,7 +143,65 @@ func NewStorage(s Specification) (*BoltStorage, error) {
 	storage := BoltStorage{
 		db,
 	}
-	return &storage, err
+	if err := storage.migrateKeys(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &storage, nil
+}
+
+// migrateKeys moves records stored under their id alone to keys that include their location.
+func (rs *BoltStorage) migrateKeys() error {
+	return rs.db.Update(func(tx *bolt.Tx) error {
+		for _, s := range AllStatuses {
+			bucket := tx.Bucket([]byte(s))
+			if bucket == nil {
+				continue
+			}
+			legacy := make(map[string][]byte)
+			err := bucket.ForEach(func(k, v []byte) error {
+				if _, located, err := unmarshalStorageKey(k); err != nil || located {
+					return err
+				}
+				legacy[string(k)] = v
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for k, v := range legacy {
+				id, _, _ := unmarshalStorageKey([]byte(k))
+				data := ArtifactData{}
+				if _, err := asn1.Unmarshal(v, &data); err != nil {
+					return err
+				}
+				artifact := data.artifact(id)
+				key, err := artifact.storageKey()
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put(key, v); err != nil {
+					return err
+				}
+				if err := bucket.Delete([]byte(k)); err != nil {
+					return err
+				}
+			}
+			if len(legacy) > 0 {
+				log.Info().Str("bucket", string(s)).Int("records", len(legacy)).Msg("Keyed records by location")
+			}
+		}
+		return nil
+	})
+}
+
+// namespaceOptional lists the formats whose packages may have no namespace, like unscoped npm packages.
+var namespaceOptional = map[string]bool{
+	"npm":   true,
+	"pypi":  true,
+	"nuget": true,
+	"go":    true,
+	"oci":   true,
 }
 
 type ValidationError struct {
@@ -77,23 +212,30 @@ func (v ValidationError) Error() string {
 	return "Validaiton problems"
 }
 
+// validate lists what stops the artifact being stored.
+func (a *Artifact) validate() []string {
+	problems := make([]string, 0)
+	if a.ArtifactId.Version == "" {
+		problems = append(problems, "Must have a non-blank version")
+	}
+	if a.ArtifactId.Package == "" {
+		problems = append(problems, "Must have a non-blank package")
+	}
+	if a.ArtifactId.Namespace == "" && !namespaceOptional[a.Format] {
+		problems = append(problems, "Must have a non-blank namespace")
+	}
+	return problems
+}
+
 func (rs *BoltStorage) Insert(artifacts ...Artifact) ([]Artifact, error) {
 	err := rs.db.Update(func(tx *bolt.Tx) error {
-		for _, artifact := range artifacts {
+		for i := range artifacts {
+			artifact := &artifacts[i]
 			if len(artifact.Problems) > 0 {
 				continue
 			}
 
-			problems := make([]string, 0)
-			if artifact.ArtifactId.Version == "" {
-				problems = append(problems, "Must have a non-blank version")
-			}
-			if artifact.ArtifactId.Package == "" {
-				problems = append(problems, "Must have a non-blank package")
-			}
-			if artifact.ArtifactId.Namespace == "" {
-				problems = append(problems, "Must have a non-blank namespace")
-			}
+			problems := artifact.validate()
 			if len(problems) > 0 {
 				artifact.Error = &ValidationError{
 					Problems: problems,
@@ -102,7 +244,6 @@ func (rs *BoltStorage) Insert(artifacts ...Artifact) ([]Artifact, error) {
 				continue
 			}
 
-			id := artifact.ArtifactId
 			data := artifact.data()
 
 			value, err := asn1.Marshal(data)
@@ -121,7 +262,7 @@ func (rs *BoltStorage) Insert(artifacts ...Artifact) ([]Artifact, error) {
 				}
 			}
 
-			key, err := id.Key()
+			key, err := artifact.storageKey()
 
 			if err != nil {
 				return err
@@ -134,6 +275,24 @@ func (rs *BoltStorage) Insert(artifacts ...Artifact) ([]Artifact, error) {
 				continue
 			}
 
+			// an artifact lives in exactly one status bucket, so a status change moves it
+			err = deleteFromOtherBuckets(tx, key, artifact.Status)
+			if err != nil {
+				artifact.Error = err
+				continue
+			}
+
+			// and replaces the copy recorded at the same place before accounts and regions were kept
+			for _, legacy := range artifact.Location().legacy() {
+				if err = deleteLocation(tx, artifact.ArtifactId, legacy); err != nil {
+					break
+				}
+			}
+			if err != nil {
+				artifact.Error = err
+				continue
+			}
+
 			if artifact.Error != nil {
 				log.Err(err).Interface("artifact", artifact).Msg("Error inserting artifact")
 			}
@@ -168,7 +327,7 @@ func (rs *BoltStorage) List(status []Status, namespaceSubstring, packageIdSubstr
 				continue
 			}
 			err := bucket.ForEach(func(k, v []byte) error {
-				id, err := UnmarshalArtifactId(k)
+				id, _, err := unmarshalStorageKey(k)
 				if err != nil {
 					return err
 				}
@@ -186,17 +345,7 @@ func (rs *BoltStorage) List(status []Status, namespaceSubstring, packageIdSubstr
 					if err != nil {
 						return err
 					}
-					results = append(results, Artifact{
-						ArtifactId: id,
-						Repository: data.Repository,
-						Revision:   data.Revision,
-						DomainName: data.DomainName,
-						Format:     data.Format,
-						Error:      nil,
-						Problems:   nil,
-						Status:     data.Status,
-						CreateTime: data.CreateTime,
-					})
+					results = append(results, data.artifact(id))
 				}
 				return nil
 			})
@@ -210,6 +359,191 @@ func (rs *BoltStorage) List(status []Status, namespaceSubstring, packageIdSubstr
 	return results, err
 }
 
+func deleteFromOtherBuckets(tx *bolt.Tx, key []byte, keep Status) error {
+	for _, s := range AllStatuses {
+		if s == keep {
+			continue
+		}
+		bucket := tx.Bucket([]byte(s))
+		if bucket == nil {
+			continue
+		}
+		err := bucket.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteLocation removes the copy of the artifact with id stored at exactly at, whatever its status.
+func deleteLocation(tx *bolt.Tx, id ArtifactId, at Location) error {
+	key, err := locationKey(id, at)
+	if err != nil {
+		return err
+	}
+	return deleteFromOtherBuckets(tx, key, "")
+}
+
+// Get returns the copy of the artifact with id stored at location, in whichever status bucket it is in, or nil
+// if it isn't stored there.
+func (rs *BoltStorage) Get(id ArtifactId, at Location) (*Artifact, error) {
+	key, err := locationKey(id, at)
+	if err != nil {
+		return nil, err
+	}
+	var found *Artifact
+	err = rs.db.View(func(tx *bolt.Tx) error {
+		for _, s := range AllStatuses {
+			bucket := tx.Bucket([]byte(s))
+			if bucket == nil {
+				continue
+			}
+			v := bucket.Get(key)
+			if v == nil {
+				continue
+			}
+			data := ArtifactData{}
+			if _, err := asn1.Unmarshal(v, &data); err != nil {
+				return err
+			}
+			artifact := data.artifact(id)
+			found = &artifact
+			return nil
+		}
+		return nil
+	})
+	return found, err
+}
+
+// Copies returns every stored copy of the artifact with id at a location matching at, in any status, by
+// domain, account and repository.
+func (rs *BoltStorage) Copies(id ArtifactId, at Location) ([]Artifact, error) {
+	prefix, err := id.Key()
+	if err != nil {
+		return nil, err
+	}
+	copies := make([]Artifact, 0)
+	err = rs.db.View(func(tx *bolt.Tx) error {
+		for _, s := range AllStatuses {
+			bucket := tx.Bucket([]byte(s))
+			if bucket == nil {
+				continue
+			}
+			c := bucket.Cursor()
+			for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+				data := ArtifactData{}
+				if _, err := asn1.Unmarshal(v, &data); err != nil {
+					return err
+				}
+				if artifact := data.artifact(id); at.matches(artifact) {
+					copies = append(copies, artifact)
+				}
+			}
+		}
+		return nil
+	})
+	sort.Slice(copies, func(i, j int) bool {
+		a, b := copies[i].Location(), copies[j].Location()
+		if a.DomainName != b.DomainName {
+			return a.DomainName < b.DomainName
+		}
+		if a.Account != b.Account {
+			return a.Account < b.Account
+		}
+		if a.Region != b.Region {
+			return a.Region < b.Region
+		}
+		return a.Repository < b.Repository
+	})
+	return copies, err
+}
+
+// ForRepository returns every stored artifact, in any status, that was imported from the repository at, in the
+// domain of at's account and region.
+func (rs *BoltStorage) ForRepository(at Location) ([]Artifact, error) {
+	results := make([]Artifact, 0)
+	err := rs.db.View(func(tx *bolt.Tx) error {
+		for _, s := range AllStatuses {
+			bucket := tx.Bucket([]byte(s))
+			if bucket == nil {
+				continue
+			}
+			err := bucket.ForEach(func(k, v []byte) error {
+				data := ArtifactData{}
+				_, err := asn1.Unmarshal(v, &data)
+				if err != nil {
+					return err
+				}
+				if data.DomainName != at.DomainName || data.Repository != at.Repository {
+					return nil
+				}
+				id, _, err := unmarshalStorageKey(k)
+				if err != nil {
+					return err
+				}
+				if artifact := data.artifact(id); at.holds(artifact) {
+					results = append(results, artifact)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// SetStatus moves the stored copies of artifacts, each at its own location, to the status bucket for status,
+// returning the artifacts that were moved. Artifacts that aren't stored are ignored.
+func (rs *BoltStorage) SetStatus(status Status, artifacts ...Artifact) ([]Artifact, error) {
+	moved := make([]Artifact, 0, len(artifacts))
+	err := rs.db.Update(func(tx *bolt.Tx) error {
+		target, err := tx.CreateBucketIfNotExists([]byte(status))
+		if err != nil {
+			return err
+		}
+		for _, a := range artifacts {
+			id := a.ArtifactId
+			key, err := a.storageKey()
+			if err != nil {
+				return err
+			}
+			for _, s := range AllStatuses {
+				bucket := tx.Bucket([]byte(s))
+				if s == status || bucket == nil {
+					continue
+				}
+				v := bucket.Get(key)
+				if v == nil {
+					continue
+				}
+				data := ArtifactData{}
+				_, err := asn1.Unmarshal(v, &data)
+				if err != nil {
+					return err
+				}
+				data.Status = status
+				value, err := asn1.Marshal(data)
+				if err != nil {
+					return err
+				}
+				if err := target.Put(key, value); err != nil {
+					return err
+				}
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+				moved = append(moved, data.artifact(id))
+			}
+		}
+		return nil
+	})
+	return moved, err
+}
+
 type Storage interface {
 	Insert(artifacts ...Artifact) ([]Artifact, error)
 	List(status []Status, namespaceSubstring, packageIdSubstring string) ([]Artifact, error)
@@ -0,0 +1,95 @@
+package artifacts
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// onlyCopy returns the one stored copy of the artifact with id, or nil if there is none.
+func onlyCopy(storage *BoltStorage, id ArtifactId) (*Artifact, error) {
+	copies, err := storage.Copies(id, Location{})
+	if err != nil || len(copies) == 0 {
+		return nil, err
+	}
+	if len(copies) > 1 {
+		return nil, fmt.Errorf("%s is stored %d times", id.Ref(), len(copies))
+	}
+	return &copies[0], nil
+}
+
+func TestCopiesInRepositoriesAreKeptApart(t *testing.T) {
+	storage := newTestStorage(t)
+	id := ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.0.0"}
+	internal := Artifact{ArtifactId: id, DomainName: "acme", Repository: "internal", Format: "maven", Status: Published, CreateTime: published}
+	release := internal
+	release.Repository = "release"
+	if _, err := storage.Insert(internal, release); err != nil {
+		t.Fatal(err)
+	}
+
+	copies, err := storage.Copies(id, Location{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copies) != 2 || copies[0].Repository != "internal" || copies[1].Repository != "release" {
+		t.Fatalf("Expected a copy in each repository, got %+v", copies)
+	}
+
+	if _, err := storage.SetStatus(Unlisted, release); err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := storage.Get(id, internal.Location()); a == nil || a.Status != Published {
+		t.Errorf("Expected the internal copy to stay published, got %+v", a)
+	}
+	if a, _ := storage.Get(id, release.Location()); a == nil || a.Status != Unlisted {
+		t.Errorf("Expected the release copy to be unlisted, got %+v", a)
+	}
+
+	if _, err := locateRef(storage, id.Ref()); err == nil {
+		t.Error("Expected a plain ref to be ambiguous")
+	}
+	if a, err := locateRef(storage, release.LocatedRef()); err != nil || a.Repository != "release" {
+		t.Errorf("Expected the located ref to find the release copy, got %+v, %v", a, err)
+	}
+}
+
+func TestNewStorageKeysLegacyRecordsByLocation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "artifacts.db")
+	artifact := Artifact{ArtifactId: ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.0.0"}, DomainName: "acme", Repository: "internal", Format: "maven", Status: Published, CreateTime: published}
+	db, err := bolt.Open(file, 0666, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte(Published))
+		if err != nil {
+			return err
+		}
+		key, _ := artifact.ArtifactId.Key()
+		value, err := asn1.Marshal(artifact.data())
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, value)
+	})
+	_ = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := NewStorage(Specification{DbFile: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = storage.db.Close() }()
+	if a, _ := storage.Get(artifact.ArtifactId, artifact.Location()); a == nil || a.Repository != "internal" {
+		t.Errorf("Expected the legacy record under its location, got %+v", a)
+	}
+	if copies, _ := storage.Copies(artifact.ArtifactId, Location{}); len(copies) != 1 {
+		t.Errorf("Expected the legacy key to be gone, got %+v", copies)
+	}
+}
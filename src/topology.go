@@ -0,0 +1,222 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/codeartifact/types"
+	"github.com/gorilla/mux"
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var repositoriesBucket = []byte("repositories")
+
+// RepositoryInfo is how a CodeArtifact repository is connected: the repositories it falls back to, in the order
+// they are searched, and the public registries it caches packages from.
+type RepositoryInfo struct {
+	DomainName          string
+	Account             string `json:",omitempty"`
+	Name                string
+	Description         string `json:",omitempty"`
+	Upstreams           []string
+	ExternalConnections []string
+	DescribedAt         time.Time
+}
+
+func topologyKey(domain, name string) []byte {
+	return []byte(domain + "/" + name)
+}
+
+func repositoryInfo(d *types.RepositoryDescription) RepositoryInfo {
+	info := RepositoryInfo{
+		DomainName:          aws.ToString(d.DomainName),
+		Account:             aws.ToString(d.DomainOwner),
+		Name:                aws.ToString(d.Name),
+		Description:         aws.ToString(d.Description),
+		Upstreams:           make([]string, 0, len(d.Upstreams)),
+		ExternalConnections: make([]string, 0, len(d.ExternalConnections)),
+		DescribedAt:         time.Now(),
+	}
+	for _, upstream := range d.Upstreams {
+		info.Upstreams = append(info.Upstreams, aws.ToString(upstream.RepositoryName))
+	}
+	for _, connection := range d.ExternalConnections {
+		info.ExternalConnections = append(info.ExternalConnections, aws.ToString(connection.ExternalConnectionName))
+	}
+	return info
+}
+
+// SaveRepository replaces what is stored about a repository.
+func (rs *BoltStorage) SaveRepository(info RepositoryInfo) error {
+	value, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return rs.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(repositoriesBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(topologyKey(info.DomainName, info.Name), value)
+	})
+}
+
+// PruneRepositories forgets the stored repositories of domain that aren't listed.
+func (rs *BoltStorage) PruneRepositories(domain string, listed []string) error {
+	keep := make(map[string]bool, len(listed))
+	for _, name := range listed {
+		keep[string(topologyKey(domain, name))] = true
+	}
+	return rs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(repositoriesBucket)
+		if bucket == nil {
+			return nil
+		}
+		prefix := topologyKey(domain, "")
+		stale := make([][]byte, 0)
+		c := bucket.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && strings.HasPrefix(string(k), string(prefix)); k, _ = c.Next() {
+			if !keep[string(k)] {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+		}
+		for _, k := range stale {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Repositories returns every stored repository, by domain and then name.
+func (rs *BoltStorage) Repositories() ([]RepositoryInfo, error) {
+	repositories := make([]RepositoryInfo, 0)
+	err := rs.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(repositoriesBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			info := RepositoryInfo{}
+			if err := json.Unmarshal(v, &info); err != nil {
+				return err
+			}
+			repositories = append(repositories, info)
+			return nil
+		})
+	})
+	return repositories, err
+}
+
+// describeRepository records how a repository is connected. Failing to find out doesn't stop the import.
+func (s *CodeArtifactWrapper) describeRepository(ctx context.Context, report *SyncReport, name string) {
+	if report.settings == nil {
+		return
+	}
+	var description *types.RepositoryDescription
+	err := retry(ctx, s.Retries, s.RetryBackoff, func() (err error) {
+		description, err = s.DescribeRepository(ctx, name)
+		return err
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("repository", name).Msg("Failed to describe repository")
+		return
+	}
+	if err := report.settings.SaveRepository(repositoryInfo(description)); err != nil {
+		log.Error().Err(err).Str("repository", name).Msg("Failed to store repository")
+	}
+}
+
+// RepositoryNode is a repository in the topology, with the repositories and external connections CodeArtifact
+// searches for a package requested from it, in order: the repository itself, then each upstream in turn,
+// depth first, each repository once.
+type RepositoryNode struct {
+	RepositoryInfo
+	ResolutionOrder []string
+	// Sources are the external connections reachable from the repository, directly or through upstreams
+	Sources []string
+}
+
+// Topology returns the stored repositories with how each resolves packages. Upstreams that aren't stored, e.g.
+// because they are skipped, appear in resolution orders without their own upstreams.
+func Topology(session *BoltStorage) ([]RepositoryNode, error) {
+	repositories, err := session.Repositories()
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]RepositoryInfo, len(repositories))
+	for _, r := range repositories {
+		byKey[string(topologyKey(r.DomainName, r.Name))] = r
+	}
+
+	nodes := make([]RepositoryNode, 0, len(repositories))
+	for _, r := range repositories {
+		node := RepositoryNode{RepositoryInfo: r, ResolutionOrder: make([]string, 0), Sources: make([]string, 0)}
+		seen := make(map[string]bool)
+		sources := make(map[string]bool)
+		var visit func(name string)
+		visit = func(name string) {
+			if seen[name] {
+				return
+			}
+			seen[name] = true
+			node.ResolutionOrder = append(node.ResolutionOrder, name)
+			upstream, ok := byKey[string(topologyKey(r.DomainName, name))]
+			if !ok {
+				return
+			}
+			for _, connection := range upstream.ExternalConnections {
+				if !sources[connection] {
+					sources[connection] = true
+					node.Sources = append(node.Sources, connection)
+				}
+			}
+			for _, next := range upstream.Upstreams {
+				visit(next)
+			}
+		}
+		visit(r.Name)
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+type RepositoriesHtmlContext struct {
+	Repositories []RepositoryNode
+}
+
+// TopologyRoutes serves GET /repositories, how the imported CodeArtifact repositories are connected to each
+// other and to public registries, as JSON when requested with Content-Type: application/json and as a page
+// otherwise.
+func TopologyRoutes(session *BoltStorage) Routes {
+	return func(r *mux.Router) {
+		r.Methods("GET").Headers("Content-Type", "application/json").Path("/repositories").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			nodes, err := Topology(session)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			jsonObjects, err := json.Marshal(nodes)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			_, _ = writer.Write(jsonObjects)
+		})
+
+		r.Methods("GET").Path("/repositories").HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			nodes, err := Topology(session)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			renderTemplate(writer, "repositories", RepositoriesHtmlContext{Repositories: nodes})
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package artifacts
+
+import (
+	"artifacts/src/codeartifacttest"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTopology(t *testing.T) {
+	server, s := fakeCodeArtifact(t)
+	server.Seed(
+		codeartifacttest.Repository{Name: "central-cache", ExternalConnections: []string{"public:maven-central"}, Packages: []codeartifacttest.Package{
+			{Format: "maven", Namespace: "junit", Name: "junit", Versions: []codeartifacttest.Version{
+				{Version: "4.13.2", Status: "Published", Published: published, ExternalConnection: "public:maven-central"},
+			}},
+		}},
+		codeartifacttest.Repository{Name: "release", Description: "Released packages", Upstreams: []string{"internal", "central-cache"}, Packages: []codeartifacttest.Package{
+			{Format: "npm", Namespace: "acme", Name: "client", Versions: []codeartifacttest.Version{{Version: "3.0.0", Status: "Published"}}},
+		}},
+	)
+	importers, err := NewImporters(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dry := newTestStorage(t)
+	DryRun(context.Background(), importers, s, dry)
+	if repositories, _ := dry.Repositories(); len(repositories) != 0 {
+		t.Errorf("Expected a dry run not to store repositories, got %+v", repositories)
+	}
+
+	storage := newTestStorage(t)
+	LoadArtifacts(context.Background(), importers, s, storage)
+	nodes, err := Topology(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, n := range nodes {
+		names = append(names, n.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"central-cache", "internal", "release"}) {
+		t.Fatalf("Expected the imported repositories, got %v", names)
+	}
+	release := nodes[2]
+	if release.Description != "Released packages" || !reflect.DeepEqual(release.ResolutionOrder, []string{"release", "internal", "central-cache"}) ||
+		!reflect.DeepEqual(release.Sources, []string{"public:maven-central"}) {
+		t.Errorf("Expected release to resolve through internal and central-cache, got %+v", release)
+	}
+
+	if a, _ := onlyCopy(storage, ArtifactId{Namespace: "junit", Package: "junit", Version: "4.13.2"}); a == nil || a.ExternalConnection != "public:maven-central" {
+		t.Errorf("Expected junit to be marked as cached from Maven Central, got %+v", a)
+	}
+	if a, _ := onlyCopy(storage, ArtifactId{Namespace: "com.acme", Package: "widget", Version: "1.0.0"}); a == nil || a.ExternalConnection != "" {
+		t.Errorf("Expected widget to be marked as published internally, got %+v", a)
+	}
+
+	LoadTemplates(Specification{Templates: "templates/"})
+	router := initRouting(storage, TopologyRoutes(storage))
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/repositories", nil))
+	if page := response.Body.String(); !strings.Contains(page, `href="#acme/central-cache"`) || !strings.Contains(page, "public:maven-central") {
+		t.Errorf("Expected the graph to link upstreams and show external connections, got %s", page)
+	}
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(response.Body.String(), "public:maven-central</span>") {
+		t.Errorf("Expected the listing to mark cached versions, got %s", response.Body)
+	}
+	request := httptest.NewRequest("GET", "/repositories", nil)
+	request.Header.Set("Content-Type", "application/json")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	served := make([]RepositoryNode, 0)
+	if err := json.NewDecoder(response.Body).Decode(&served); err != nil || len(served) != 3 {
+		t.Errorf("Expected the topology as JSON, got %v %+v", err, served)
+	}
+
+	server.RemoveRepository("central-cache")
+	LoadArtifacts(context.Background(), importers, s, storage)
+	if repositories, _ := storage.Repositories(); len(repositories) != 2 {
+		t.Errorf("Expected the removed repository to be forgotten, got %+v", repositories)
+	}
+}